@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// AlertmanagerResponseError represents a non 2xx or undecodable response from Alert Manager API
+type AlertmanagerResponseError struct {
+	URL        string
+	StatusCode int
+	Body       []byte
+	Err        error
+}
+
+func (e *AlertmanagerResponseError) Error() string {
+	trim := 64
+	if e.Err != nil {
+		return fmt.Sprintf("cannot decode response from %s: %v\nFirst %d bytes of response: %s", e.URL, e.Err, trim, trimBody(e.Body, trim))
+	}
+	return fmt.Sprintf("GET %s failed with status %d %s\nFirst %d bytes of response: %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode), trim, trimBody(e.Body, trim))
+}
+
+func (e *AlertmanagerResponseError) Unwrap() error {
+	return e.Err
+}
+
+// stdinSource is used in --alert-manager-api-url to read alerts from stdin
+const stdinSource = "-"
+
+// fileSourcePrefix is used in --alert-manager-api-url to read alerts from a file
+const fileSourcePrefix = "file://"
+
+// stdin is read only once, so daemon mode uses the same alerts in each run. It is replaced in tests
+var (
+	stdin       io.Reader = os.Stdin
+	stdinOnce   sync.Once
+	stdinAlerts []byte
+	stdinErr    error
+)
+
+// check if alerts are read from a file or stdin instead of alert manager api
+func isOfflineSource(u string) bool {
+	return u == stdinSource || strings.HasPrefix(u, fileSourcePrefix)
+}
+
+// read alerts dump (alert manager /api/v2/alerts response) from a file or stdin
+func readOfflineAlerts(u string) ([]byte, error) {
+	if u == stdinSource {
+		stdinOnce.Do(func() {
+			stdinAlerts, stdinErr = ioutil.ReadAll(stdin)
+		})
+		return stdinAlerts, stdinErr
+	}
+	return ioutil.ReadFile(strings.TrimPrefix(u, fileSourcePrefix))
+}
+
+// alertmanagerTLSConfig is used only in alert manager connections
+// sensu backend connections use tlsConfig
+var alertmanagerTLSConfig tls.Config
+
+// validate alert manager auth flags and load TLS files
+func checkAlertmanagerAuth() error {
+	if plugin.AlertmanagerUser != "" && (plugin.AlertmanagerBearerToken != "" || plugin.AlertmanagerBearerTokenFile != "") {
+		return fmt.Errorf("Cannot use --alert-manager-user and --alert-manager-bearer-token or --alert-manager-bearer-token-file together")
+	}
+	if plugin.AlertmanagerBearerToken != "" && plugin.AlertmanagerBearerTokenFile != "" {
+		return fmt.Errorf("Cannot use --alert-manager-bearer-token and --alert-manager-bearer-token-file together")
+	}
+	if _, err := parseHeaders(plugin.AlertmanagerHeaders); err != nil {
+		return fmt.Errorf("Please use Format: Header=Value. Wrong format --alert-manager-headers %s: %v", plugin.AlertmanagerHeaders, err)
+	}
+	alertmanagerTLSConfig = tls.Config{
+		InsecureSkipVerify: plugin.AlertmanagerInsecure,
+	}
+	if len(plugin.AlertmanagerTrustedCAFile) > 0 {
+		caCertPool, err := v2.LoadCACerts(plugin.AlertmanagerTrustedCAFile)
+		if err != nil {
+			return fmt.Errorf("Error loading specified Alert Manager CA file: %v", err)
+		}
+		alertmanagerTLSConfig.RootCAs = caCertPool
+	}
+	if plugin.AlertmanagerCertFile != "" || plugin.AlertmanagerKeyFile != "" {
+		if plugin.AlertmanagerCertFile == "" || plugin.AlertmanagerKeyFile == "" {
+			return fmt.Errorf("Please use --alert-manager-cert-file and --alert-manager-key-file together")
+		}
+		cert, err := tls.LoadX509KeyPair(plugin.AlertmanagerCertFile, plugin.AlertmanagerKeyFile)
+		if err != nil {
+			return fmt.Errorf("Error loading specified Alert Manager certificate and key: %v", err)
+		}
+		alertmanagerTLSConfig.Certificates = []tls.Certificate{cert}
+	}
+	return nil
+}
+
+// add basic auth, bearer token and extra headers in alert manager requests
+// bearer token file is read in each request because it can be rotated
+func setAlertmanagerAuth(req *http.Request) error {
+	headers, err := parseHeaders(plugin.AlertmanagerHeaders)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if plugin.AlertmanagerUser != "" {
+		req.SetBasicAuth(plugin.AlertmanagerUser, plugin.AlertmanagerPass)
+	}
+	token := plugin.AlertmanagerBearerToken
+	if plugin.AlertmanagerBearerTokenFile != "" {
+		content, err := ioutil.ReadFile(plugin.AlertmanagerBearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("error reading bearer token file %s: %v", plugin.AlertmanagerBearerTokenFile, err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	return nil
+}
+
+// parse headers like X-Scope-OrgID=tenant1,Header=Value
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range splitList(s) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return headers, fmt.Errorf("invalid header %q", pair)
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return headers, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeaders(t *testing.T) {
+	res1, err1 := parseHeaders("X-Scope-OrgID=tenant1, X-Extra=a=b")
+	assert.NoError(t, err1)
+	assert.Equal(t, map[string]string{"X-Scope-OrgID": "tenant1", "X-Extra": "a=b"}, res1)
+	_, err2 := parseHeaders("X-Scope-OrgID")
+	assert.Error(t, err2)
+	res3, err3 := parseHeaders("")
+	assert.NoError(t, err3)
+	assert.Empty(t, res3)
+}
+
+func TestCheckAlertmanagerAuth(t *testing.T) {
+	plugin.AlertmanagerUser = "admin"
+	plugin.AlertmanagerBearerToken = "token"
+	assert.Error(t, checkAlertmanagerAuth())
+	plugin.AlertmanagerUser = ""
+	plugin.AlertmanagerBearerTokenFile = "/tmp/token"
+	assert.Error(t, checkAlertmanagerAuth())
+	plugin.AlertmanagerBearerToken = ""
+	plugin.AlertmanagerBearerTokenFile = ""
+	plugin.AlertmanagerCertFile = "/tmp/cert.pem"
+	assert.Error(t, checkAlertmanagerAuth())
+	plugin.AlertmanagerCertFile = ""
+	plugin.AlertmanagerHeaders = "X-Scope-OrgID"
+	assert.Error(t, checkAlertmanagerAuth())
+	plugin.AlertmanagerHeaders = ""
+	assert.NoError(t, checkAlertmanagerAuth())
+}
+
+func TestSetAlertmanagerAuth(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "sensu-alertmanager-events")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(ioutil.WriteFile(tokenFile, []byte("token1\n"), 0600))
+
+	plugin.AlertmanagerHeaders = "X-Scope-OrgID=tenant1"
+	plugin.AlertmanagerBearerTokenFile = tokenFile
+	req, _ := http.NewRequest(http.MethodGet, "http://am1:9093/api/v2/alerts", nil)
+	assert.NoError(setAlertmanagerAuth(req))
+	assert.Equal("tenant1", req.Header.Get("X-Scope-OrgID"))
+	assert.Equal("Bearer token1", req.Header.Get("Authorization"))
+	// token file is read again
+	assert.NoError(ioutil.WriteFile(tokenFile, []byte("token2"), 0600))
+	req, _ = http.NewRequest(http.MethodGet, "http://am1:9093/api/v2/alerts", nil)
+	assert.NoError(setAlertmanagerAuth(req))
+	assert.Equal("Bearer token2", req.Header.Get("Authorization"))
+	plugin.AlertmanagerBearerTokenFile = ""
+
+	plugin.AlertmanagerUser = "admin"
+	plugin.AlertmanagerPass = "secret"
+	req, _ = http.NewRequest(http.MethodGet, "http://am1:9093/api/v2/alerts", nil)
+	assert.NoError(setAlertmanagerAuth(req))
+	user, pass, ok := req.BasicAuth()
+	assert.True(ok)
+	assert.Equal("admin", user)
+	assert.Equal("secret", pass)
+	plugin.AlertmanagerUser = ""
+	plugin.AlertmanagerPass = ""
+	plugin.AlertmanagerHeaders = ""
+}
+
+func TestGetAlertsTLS(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("tenant1", r.Header.Get("X-Scope-OrgID"))
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "sensu-alertmanager-events")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(ioutil.WriteFile(caFile, caPEM, 0600))
+
+	plugin.AlertmanagerHeaders = "X-Scope-OrgID=tenant1"
+	assert.NoError(checkAlertmanagerAuth())
+	_, err = getAlerts(server.URL)
+	assert.Error(err)
+
+	plugin.AlertmanagerTrustedCAFile = caFile
+	assert.NoError(checkAlertmanagerAuth())
+	body, err := getAlerts(server.URL)
+	assert.NoError(err)
+	assert.Equal("[]", string(body))
+	plugin.AlertmanagerTrustedCAFile = ""
+	plugin.AlertmanagerHeaders = ""
+	assert.NoError(checkAlertmanagerAuth())
+}
+
+func TestGetAlertManagerAlertsErrors(t *testing.T) {
+	assert := assert.New(t)
+	testcases := []struct {
+		httpStatus int
+		body       string
+		statusCode int
+		decodeErr  bool
+	}{
+		{http.StatusUnauthorized, "Unauthorized", http.StatusUnauthorized, false},
+		{http.StatusBadGateway, "<html>Bad Gateway</html>", http.StatusBadGateway, false},
+		{http.StatusOK, "<html>Sign in</html>", http.StatusOK, true},
+	}
+	for _, tc := range testcases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.httpStatus)
+			_, _ = w.Write([]byte(tc.body))
+		}))
+		_, err := getAlertManagerAlerts(AlertmanagerSource{URL: server.URL})
+		assert.Error(err)
+		var responseErr *AlertmanagerResponseError
+		assert.True(errors.As(err, &responseErr))
+		assert.Equal(tc.statusCode, responseErr.StatusCode)
+		assert.Equal(tc.decodeErr, responseErr.Err != nil)
+		server.Close()
+	}
+}
+
+func TestReadOfflineAlerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alerts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "alerts.json")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`[]`), 0600))
+	body, err := readOfflineAlerts("file://" + file)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", string(body))
+	_, err = readOfflineAlerts("file://" + filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+	assert.True(t, isOfflineSource("-"))
+	assert.False(t, isOfflineSource("http://alertmanager:9093/api/v2/alerts"))
+}
+
+func TestGetAlertManagerEventsOffline(t *testing.T) {
+	now := time.Now()
+	dir, err := ioutil.TempDir("", "alerts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dump, _ := json.Marshal([]models.GettableAlert{testAlert("a", "TargetDown", now), testAlert("b", "NodeDown", now)})
+	file := filepath.Join(dir, "alerts.json")
+	assert.NoError(t, ioutil.WriteFile(file, dump, 0600))
+	broken := filepath.Join(dir, "broken.json")
+	assert.NoError(t, ioutil.WriteFile(broken, []byte(`{"alerts":`), 0600))
+	stdin = strings.NewReader(`[]`)
+	defer func() { stdin = os.Stdin }()
+
+	plugin.Sources = []AlertmanagerSource{{URL: "file://" + file}, {URL: "-"}}
+	alerts, unreachable, err := getAlertManagerEvents()
+	assert.NoError(t, err)
+	assert.Empty(t, unreachable)
+	assert.Len(t, alerts, 2)
+
+	plugin.Sources = []AlertmanagerSource{{URL: "file://" + broken}}
+	_, _, err = getAlertManagerEvents()
+	var responseErr *AlertmanagerResponseError
+	assert.True(t, errors.As(err, &responseErr))
+	plugin.Sources = nil
+}
+
+func TestGetAlertManagerAlertsInvalid(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "alerts")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	noFingerprint := testAlert("a", "TargetDown", time.Now())
+	noFingerprint.Fingerprint = nil
+	noStatus := testAlert("b", "NodeDown", time.Now())
+	noStatus.Status = nil
+	testcases := map[string]string{
+		"fingerprint": marshalAlerts(noFingerprint),
+		"status":      marshalAlerts(noStatus),
+		"empty":       `[{}]`,
+		"partial":     `[{"labels":{"alertname":"X"},"status":{"state":"active"}}]`,
+	}
+	for field, body := range testcases {
+		file := filepath.Join(dir, field+".json")
+		assert.NoError(ioutil.WriteFile(file, []byte(body), 0600))
+		_, err := getAlertManagerAlerts(AlertmanagerSource{URL: "file://" + file})
+		var responseErr *AlertmanagerResponseError
+		if assert.True(errors.As(err, &responseErr), field) {
+			assert.Error(responseErr.Err)
+		}
+	}
+	file := filepath.Join(dir, "valid.json")
+	assert.NoError(ioutil.WriteFile(file, []byte(marshalAlerts(testAlert("a", "TargetDown", time.Now()))), 0600))
+	alerts, err := getAlertManagerAlerts(AlertmanagerSource{URL: "file://" + file})
+	assert.NoError(err)
+	assert.Len(alerts, 1)
+}
+
+func marshalAlerts(alerts ...models.GettableAlert) string {
+	body, _ := json.Marshal(alerts)
+	return string(body)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// delivery modes used in --delivery
+const (
+	deliveryAgent   = "agent"
+	deliveryBackend = "backend"
+)
+
+var deliveryModes = []string{deliveryAgent, deliveryBackend, deliveryTCP, deliveryUDP}
+
+// get sensu backend access token before posting events when using --delivery backend
+func prepareDelivery() error {
+	if plugin.Delivery != deliveryBackend || len(plugin.APIBackendKey) != 0 {
+		return nil
+	}
+	_, err := backendToken()
+	return err
+}
+
+// submit event to sensu agent api, sensu agent socket or sensu backend api
+func submitEvent(event *v2.Event) error {
+	limiter.acquire()
+	defer limiter.release()
+	switch plugin.Delivery {
+	case deliveryBackend:
+		return submitEventBackendAPI(event)
+	case deliveryTCP, deliveryUDP:
+		return submitEventAgentSocket(event)
+	}
+	return submitEventAgentAPI(event)
+}
+
+// put event in sensu backend api. Proxy entity is created if it doesn't exist
+func submitEventBackendAPI(event *v2.Event) error {
+	entityName := event.Check.ProxyEntityName
+	if entityName == "" {
+		entityName = plugin.SensuAgentEntity
+	}
+	if entityName == "" {
+		return fmt.Errorf("cannot post event %s without entity. Please use --sensu-agent-entity or a proxy entity", event.Check.Name)
+	}
+	namespace := event.Check.Namespace
+	entity, err := getOrCreateEntity(entityName, namespace)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	event.Entity = entity
+	event.Timestamp = now
+	event.Check.Executed = now
+	event.Check.Issued = now
+
+	encoded, _ := json.Marshal(event)
+	eventURL := backendURL(fmt.Sprintf("/api/core/v2/namespaces/%s/events/%s/%s", url.PathEscape(namespace), url.PathEscape(entityName), url.PathEscape(event.Check.Name)))
+	_, err = backendRequest(http.MethodPut, eventURL, encoded)
+	if err != nil {
+		return fmt.Errorf("PUT of event to %s failed: %v\nevent: %s", eventURL, err, string(encoded))
+	}
+	return nil
+}
+
+// get entity from sensu backend api or create a proxy entity if it doesn't exist
+func getOrCreateEntity(name, namespace string) (*v2.Entity, error) {
+	entityURL := backendURL(fmt.Sprintf("/api/core/v2/namespaces/%s/entities/%s", url.PathEscape(namespace), url.PathEscape(name)))
+	body, err := backendRequest(http.MethodGet, entityURL, nil)
+	if err == nil {
+		entity := &v2.Entity{}
+		err = json.Unmarshal(body, entity)
+		if err != nil {
+			trim := 64
+			return nil, fmt.Errorf("error unmarshalling entity %s: %v\nFirst %d bytes of response: %s", name, err, trim, trimBody(body, trim))
+		}
+		return entity, nil
+	}
+	if statusErr, ok := err.(*backendStatusError); !ok || statusErr.StatusCode != http.StatusNotFound {
+		return nil, fmt.Errorf("GET of entity %s failed: %v", name, err)
+	}
+	entity := &v2.Entity{
+		ObjectMeta: v2.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			CreatedBy: plugin.Name,
+			Labels:    map[string]string{plugin.Name: "owner"},
+		},
+		EntityClass:   v2.EntityProxyClass,
+		Subscriptions: []string{v2.GetEntitySubscription(name)},
+	}
+	encoded, _ := json.Marshal(entity)
+	_, err = backendRequest(http.MethodPut, entityURL, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("PUT of proxy entity %s failed: %v", name, err)
+	}
+	return entity, nil
+}
+
+// backendStatusError represents a non 2xx response from sensu backend api
+type backendStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *backendStatusError) Error() string {
+	trim := 64
+	return fmt.Sprintf("%s %s failed with status %d\nFirst %d bytes of response: %s", e.Method, e.URL, e.StatusCode, trim, trimBody(e.Body, trim))
+}
+
+// send request to sensu backend api using api key or access token. GET and PUT requests are idempotent, so they are retried
+func backendRequest(method, requestURL string, payload []byte) ([]byte, error) {
+	resp, err := doWithBackendAuth(func(auth Auth) (*http.Response, error) {
+		return doWithRetry(backendClient(), targetBackend, func() (*http.Request, error) {
+			req, err := http.NewRequest(method, requestURL, bytes.NewReader(payload))
+			if err != nil {
+				return nil, fmt.Errorf("error creating %s request for %s: %v", method, requestURL, err)
+			}
+			setBackendAuthHeader(req, auth)
+			req.Header.Set("Content-Type", "application/json")
+			return req, nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error executing %s request for %s: %v", method, requestURL, err)
+	}
+	defer resp.Body.Close()
+	result, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body for %s: %v", requestURL, err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return result, &backendStatusError{Method: method, URL: requestURL, StatusCode: resp.StatusCode, Body: result}
+	}
+	return result, nil
+}
+
+// add api key or access token in sensu backend requests
+func setBackendAuthHeader(req *http.Request, auth Auth) {
+	if len(plugin.APIBackendKey) == 0 {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", auth.AccessToken))
+	} else {
+		req.Header.Set("Authorization", fmt.Sprintf("Key %s", plugin.APIBackendKey))
+	}
+}
+
+// http client for sensu backend api using api-backend-* TLS flags
+func backendClient() *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	}
+	if plugin.Secure {
+		transport.TLSClientConfig = &tlsConfig
+	}
+	return &http.Client{
+		Timeout:   time.Second * 10,
+		Transport: transport,
+	}
+}
+
+func backendURL(path string) string {
+	return fmt.Sprintf("%s://%s:%d%s", plugin.Protocol, plugin.APIBackendHost, plugin.APIBackendPort, path)
+}
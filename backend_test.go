@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// point api-backend-* flags to a test server
+func setTestBackend(t *testing.T, server *httptest.Server) {
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	port, _ := strconv.Atoi(u.Port())
+	plugin.APIBackendHost = u.Hostname()
+	plugin.APIBackendPort = port
+	plugin.Protocol = "http"
+}
+
+func TestSubmitEventBackendAPI(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	entities := map[string]*v2.Entity{}
+	events := map[string]*v2.Event{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal("Key key", r.Header.Get("Authorization"))
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/core/v2/namespaces/default/entities/agent1":
+			entity := v2.FixtureEntity("agent1")
+			body, _ := json.Marshal(entity)
+			_, _ = w.Write(body)
+		case r.Method == http.MethodGet:
+			http.NotFound(w, r)
+		case r.Method == http.MethodPut && r.URL.Path == "/api/core/v2/namespaces/default/entities/proxy1":
+			entity := &v2.Entity{}
+			assert.NoError(json.Unmarshal(body, entity))
+			entities[entity.Name] = entity
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut:
+			event := &v2.Event{}
+			assert.NoError(json.Unmarshal(body, event))
+			events[r.URL.Path] = event
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer backend.Close()
+	setTestBackend(t, backend)
+	plugin.APIBackendKey = "key"
+	plugin.Delivery = deliveryBackend
+
+	check := func(name, proxy string) *v2.Event {
+		return &v2.Event{
+			Check: &v2.Check{
+				ObjectMeta:      v2.ObjectMeta{Name: name, Namespace: "default"},
+				ProxyEntityName: proxy,
+				Status:          2,
+			},
+		}
+	}
+	assert.NoError(submitEvent(check("TargetDown", "proxy1")))
+	assert.Contains(entities, "proxy1")
+	assert.Equal(v2.EntityProxyClass, entities["proxy1"].EntityClass)
+	ev := events["/api/core/v2/namespaces/default/events/proxy1/TargetDown"]
+	if assert.NotNil(ev) {
+		assert.Equal("proxy1", ev.Entity.Name)
+		assert.Equal(uint32(2), ev.Check.Status)
+		assert.NotZero(ev.Timestamp)
+	}
+
+	plugin.SensuAgentEntity = "agent1"
+	assert.NoError(submitEvent(check("TargetDown", "")))
+	ev = events["/api/core/v2/namespaces/default/events/agent1/TargetDown"]
+	if assert.NotNil(ev) {
+		assert.Equal("host", ev.Entity.EntityClass)
+	}
+	assert.NotContains(entities, "agent1")
+
+	plugin.SensuAgentEntity = ""
+	assert.Error(submitEvent(check("TargetDown", "")))
+
+	plugin.APIBackendKey = ""
+	plugin.Delivery = ""
+}
+
+func TestPrepareDelivery(t *testing.T) {
+	resetTokenCache()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/auth", r.URL.Path)
+		_, _ = w.Write([]byte(`{"access_token":"token","refresh_token":"refresh","expires_at":0}`))
+	}))
+	defer backend.Close()
+	setTestBackend(t, backend)
+
+	plugin.Delivery = deliveryAgent
+	assert.NoError(t, prepareDelivery())
+	assert.Equal(t, "", tokenCache.auth.AccessToken)
+
+	plugin.Delivery = deliveryBackend
+	assert.NoError(t, prepareDelivery())
+	assert.Equal(t, "token", tokenCache.auth.AccessToken)
+
+	req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+	setBackendAuthHeader(req, tokenCache.auth)
+	assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+
+	resetTokenCache()
+	plugin.Delivery = ""
+}
+
+func TestGetEventsPagination(t *testing.T) {
+	assert := assert.New(t)
+	pages := map[string][]*v2.Event{
+		"":      {testSensuEvent("TargetDown", "a"), testSensuEvent("NodeDown", "b")},
+		"page2": {testSensuEvent("PodDown", "c")},
+	}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		assert.Equal("/api/core/v2/namespaces/default/events", r.URL.Path)
+		assert.Equal("2", query.Get("limit"))
+		assert.Equal(plugin.Name+" == owner", query.Get("labelSelector"))
+		assert.Equal("event.check.status != 0", query.Get("fieldSelector"))
+		token := query.Get("continue")
+		if token == "" {
+			w.Header().Set("Sensu-Continue", "page2")
+		}
+		body, _ := json.Marshal(pages[token])
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+	setTestBackend(t, backend)
+	plugin.APIBackendKey = "key"
+	plugin.APIBackendPageSize = 2
+
+	var received [][]string
+	err := getEvents("default", func(events []*v2.Event) error {
+		names := []string{}
+		for _, e := range events {
+			names = append(names, e.Check.Name)
+		}
+		received = append(received, names)
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal([][]string{{"TargetDown", "NodeDown"}, {"PodDown"}}, received)
+
+	plugin.APIBackendKey = ""
+	plugin.APIBackendPageSize = 0
+}
+
+func TestGetEventsError(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"message":"unauthorized"}`))
+	}))
+	defer backend.Close()
+	setTestBackend(t, backend)
+	err := getEvents("default", func(events []*v2.Event) error {
+		t.Error("no events expected")
+		return nil
+	})
+	assert.Error(t, err)
+}
+
+func TestAutoCloseNamespaces(t *testing.T) {
+	plugin.SensuNamespace = "default"
+	assert.Equal(t, []string{"default"}, autoCloseNamespaces())
+	plugin.AutoCloseNamespaces = "team-a, team-b"
+	assert.Equal(t, []string{"team-a", "team-b"}, autoCloseNamespaces())
+	plugin.AutoCloseNamespaces = "*"
+	assert.Equal(t, []string{""}, autoCloseNamespaces())
+	plugin.AutoCloseNamespaces = ""
+	plugin.SensuNamespace = ""
+}
+
+func TestRunCheckAutoCloseAllNamespaces(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	closed := []string{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/core/v2/events":
+			teamA := testSensuEvent("PodDown", "z")
+			teamA.Check.Namespace = "team-a"
+			body, _ := json.Marshal([]*v2.Event{teamA, testSensuEvent("TargetDown", "a")})
+			_, _ = w.Write(body)
+		case r.Method == http.MethodGet:
+			body, _ := json.Marshal(v2.FixtureEntity("PodDown"))
+			_, _ = w.Write(body)
+		case r.Method == http.MethodPut:
+			closed = append(closed, r.URL.Path)
+		}
+	}))
+	defer backend.Close()
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := json.Marshal([]models.GettableAlert{testAlert("a", "TargetDown", time.Now())})
+		_, _ = w.Write(body)
+	}))
+	defer am.Close()
+	setTestBackend(t, backend)
+	plugin.APIBackendKey = "key"
+	plugin.Delivery = deliveryBackend
+	plugin.SensuAutoClose = true
+	plugin.AutoCloseNamespaces = "*"
+	plugin.SensuNamespace = "default"
+	plugin.Sources = []AlertmanagerSource{{URL: am.URL}}
+
+	status, err := runCheck()
+	assert.NoError(err)
+	assert.Equal(sensu.CheckStateOK, status)
+	assert.Contains(closed, "/api/core/v2/namespaces/team-a/events/PodDown/PodDown")
+	assert.NotContains(closed, "/api/core/v2/namespaces/default/events/PodDown/PodDown")
+
+	plugin.Delivery = deliveryAgent
+	status, err = checkArgs(nil)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "--auto-close-namespaces")
+	}
+	assert.Equal(sensu.CheckStateWarning, status)
+
+	plugin.Sources = nil
+	plugin.SensuNamespace = ""
+	plugin.AutoCloseNamespaces = ""
+	plugin.SensuAutoClose = false
+	plugin.Delivery = ""
+	plugin.APIBackendKey = ""
+}
+
+func TestRunCheckAutoCloseAgentEntity(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	requests := []string{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/core/v2/namespaces/team-a/events":
+			// event created without proxy entity, it belongs to the agent entity
+			event := testSensuEvent("PodDown", "z")
+			event.Entity = v2.FixtureEntity("agent-in-team-a")
+			event.Check.ProxyEntityName = ""
+			event.Check.Namespace = "team-a"
+			body, _ := json.Marshal([]*v2.Event{event})
+			_, _ = w.Write(body)
+		case r.Method == http.MethodGet:
+			body, _ := json.Marshal(v2.FixtureEntity("agent-in-team-a"))
+			_, _ = w.Write(body)
+		}
+	}))
+	defer backend.Close()
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer am.Close()
+	setTestBackend(t, backend)
+	plugin.APIBackendKey = "key"
+	plugin.Delivery = deliveryBackend
+	plugin.SensuAgentEntity = "bridge-host"
+	plugin.SensuAutoClose = true
+	plugin.AutoCloseNamespaces = "team-a"
+	plugin.SensuNamespace = "default"
+	plugin.Sources = []AlertmanagerSource{{URL: am.URL}}
+
+	status, err := runCheck()
+	assert.NoError(err)
+	assert.Equal(sensu.CheckStateOK, status)
+	assert.Contains(requests, "PUT /api/core/v2/namespaces/team-a/events/agent-in-team-a/PodDown")
+	for _, request := range requests {
+		assert.NotContains(request, "bridge-host")
+	}
+
+	plugin.Sources = nil
+	plugin.SensuNamespace = ""
+	plugin.AutoCloseNamespaces = ""
+	plugin.SensuAutoClose = false
+	plugin.SensuAgentEntity = ""
+	plugin.Delivery = ""
+	plugin.APIBackendKey = ""
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+)
+
+// shutdownTimeout is the max time to wait in-flight requests when stopping
+const shutdownTimeout = 30 * time.Second
+
+// ready is used by /readyz endpoint in daemon and serve modes
+var ready int32
+
+// run check in a loop until SIGTERM
+func runDaemon() (int, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	server := &http.Server{
+		Addr:    plugin.DaemonListenAddress,
+		Handler: newServeMux(),
+	}
+	// stop daemon loop if http server fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	serverErrors := make(chan error, 1)
+	go func() {
+		serverErrors <- serveHTTP(ctx, server)
+		cancel()
+	}()
+	log.Printf("Starting daemon with interval %d seconds. Health endpoints on %s", plugin.DaemonInterval, plugin.DaemonListenAddress)
+	daemonLoop(ctx, time.Duration(plugin.DaemonInterval)*time.Second)
+	log.Println("Stopping daemon")
+	if err := <-serverErrors; err != nil {
+		return sensu.CheckStateCritical, err
+	}
+	return sensu.CheckStateOK, nil
+}
+
+// run check each interval. A running check is never interrupted, so all in-flight posts are finished before it returns
+func daemonLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := runCheck()
+		if err != nil {
+			log.Printf("[ERROR] check finished with status %d: %v", status, err)
+		}
+		setReady(status < sensu.CheckStateCritical)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// http mux with health and metrics endpoints
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+// start http server and stop it gracefully when ctx is done
+func serveHTTP(ctx context.Context, server *http.Server) error {
+	serverErrors := make(chan error, 1)
+	go func() {
+		serverErrors <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-serverErrors:
+		return err
+	case <-ctx.Done():
+		setReady(false)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+func setReady(value bool) {
+	var v int32
+	if value {
+		v = 1
+	}
+	atomic.StoreInt32(&ready, v)
+}
+
+// liveness probe
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// readiness probe. Not ready before first check or if last check was critical
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&ready) == 0 {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
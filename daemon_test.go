@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	mux := newServeMux()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	setReady(false)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	setReady(true)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	setReady(false)
+}
+
+func TestDaemonLoop(t *testing.T) {
+	var calls int32
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer am.Close()
+	plugin.Sources = []AlertmanagerSource{{URL: am.URL}}
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	daemonLoop(ctx, 50*time.Millisecond)
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&calls), int32(2))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&ready))
+
+	// critical check makes it not ready
+	plugin.Sources = nil
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	daemonLoop(ctx, time.Second)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&ready))
+}
+
+func TestServeHTTPShutdown(t *testing.T) {
+	server := &http.Server{Addr: "127.0.0.1:0", Handler: newServeMux()}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serveHTTP(ctx, server)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Error("server did not stop")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// actions used in dry run report
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionClose  = "close"
+	actionSkip   = "skip"
+)
+
+// formats used in --dry-run-format
+var dryRunFormats = []string{"table", "json"}
+
+// DryRunEntry is one line in dry run report
+type DryRunEntry struct {
+	Action      string    `json:"action"`
+	Reason      string    `json:"reason,omitempty"`
+	Alert       string    `json:"alert,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Event       *v2.Event `json:"event,omitempty"`
+}
+
+// dryRunReport keeps what would be sent to sensu in the current run
+type dryRunReport struct {
+	sync.Mutex
+	entries  []DryRunEntry
+	existing map[string]bool
+}
+
+var report = &dryRunReport{}
+
+func (r *dryRunReport) reset() {
+	r.Lock()
+	defer r.Unlock()
+	r.entries = nil
+	r.existing = nil
+}
+
+func (r *dryRunReport) add(entry DryRunEntry) {
+	if !plugin.DryRun {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+// keep events from sensu backend, so created events can be reported as updates
+func (r *dryRunReport) addExisting(events []*v2.Event) {
+	r.Lock()
+	defer r.Unlock()
+	if r.existing == nil {
+		r.existing = map[string]bool{}
+	}
+	for _, e := range events {
+		if e.Entity == nil || e.Check == nil {
+			continue
+		}
+		r.existing[eventKey(e.Check.Namespace, e.Entity.Name, e.Check.Name)] = true
+		r.existing[eventKey(e.Check.Namespace, "", e.Check.Name)] = true
+	}
+}
+
+// entries with create action changed to update if event exists in sensu backend, sorted by action, entity and check
+func (r *dryRunReport) result() []DryRunEntry {
+	r.Lock()
+	defer r.Unlock()
+	result := make([]DryRunEntry, len(r.entries))
+	copy(result, r.entries)
+	for i, entry := range result {
+		if entry.Action == actionCreate && r.existing[eventKey(entry.Event.Check.Namespace, eventEntity(entry.Event), entry.Event.Check.Name)] {
+			result[i].Action = actionUpdate
+		}
+	}
+	order := map[string]int{actionCreate: 0, actionUpdate: 1, actionClose: 2, actionSkip: 3}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Action != b.Action {
+			return order[a.Action] < order[b.Action]
+		}
+		if eventEntity(a.Event) != eventEntity(b.Event) {
+			return eventEntity(a.Event) < eventEntity(b.Event)
+		}
+		if entryName(a) != entryName(b) {
+			return entryName(a) < entryName(b)
+		}
+		return a.Fingerprint < b.Fingerprint
+	})
+	return result
+}
+
+// print dry run report using --dry-run-format
+func (r *dryRunReport) print(w io.Writer) error {
+	entries := r.result()
+	if plugin.DryRunFormat == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tENTITY\tCHECK\tSTATUS\tREASON")
+	for _, entry := range entries {
+		entity, status := "-", "-"
+		if entry.Event != nil {
+			entity = eventEntity(entry.Event)
+			status = fmt.Sprintf("%d", entry.Event.Check.Status)
+		}
+		reason := entry.Reason
+		if reason == "" {
+			reason = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Action, entity, entryName(entry), status, reason)
+	}
+	return tw.Flush()
+}
+
+// print dry run report in stdout
+func printDryRun() {
+	if !plugin.DryRun {
+		return
+	}
+	err := report.print(os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[ERROR] cannot print dry run report: %v\n", err)
+	}
+}
+
+// count filtered alert by reason and add it in dry run report
+func skipAlert(a models.GettableAlert, reason string) {
+	alertsFilteredTotal.WithLabelValues(reason).Inc()
+	fingerprint := ""
+	if a.Fingerprint != nil {
+		fingerprint = *a.Fingerprint
+	}
+	report.add(DryRunEntry{Action: actionSkip, Reason: reason, Alert: a.Labels["alertname"], Fingerprint: fingerprint})
+}
+
+// entity used by sensu for an event
+func eventEntity(event *v2.Event) string {
+	if event == nil {
+		return ""
+	}
+	if event.Check.ProxyEntityName != "" {
+		return event.Check.ProxyEntityName
+	}
+	return plugin.SensuAgentEntity
+}
+
+func entryName(entry DryRunEntry) string {
+	if entry.Event != nil {
+		return entry.Event.Check.Name
+	}
+	return entry.Alert
+}
+
+func eventKey(namespace, entity, check string) string {
+	return namespace + "/" + entity + "/" + check
+}
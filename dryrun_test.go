@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSensuEvent(name, fingerprint string) *v2.Event {
+	event := v2.FixtureEvent(name, name)
+	event.Check.Status = 2
+	event.Check.ProxyEntityName = name
+	event.Check.Labels = map[string]string{plugin.Name: "owner", "fingerprint": fingerprint, "alertname": name}
+	return event
+}
+
+func TestRunCheckDryRun(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("sensu agent must not be called in dry run: %s", r.URL.Path)
+	}))
+	defer agent.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(http.MethodGet, r.Method)
+		body, _ := json.Marshal([]*v2.Event{testSensuEvent("TargetDown", "a"), testSensuEvent("PodDown", "z")})
+		_, _ = w.Write(body)
+	}))
+	defer backend.Close()
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dev := testAlert("d", "DiskFull", now)
+		dev.Labels["env"] = "dev"
+		body, _ := json.Marshal([]models.GettableAlert{testAlert("a", "TargetDown", now), testAlert("b", "NodeDown", now), testAlert("c", "Watchdog", now), dev})
+		_, _ = w.Write(body)
+	}))
+	defer am.Close()
+	setTestBackend(t, backend)
+	plugin.AgentAPIURL = agent.URL
+	plugin.APIBackendKey = "key"
+	plugin.SensuAutoClose = true
+	plugin.AlertmanagerExcludeAlerts = "Watchdog,InfoInhibitor"
+	plugin.Sources = []AlertmanagerSource{{URL: am.URL}}
+	plugin.DryRun = true
+	plugin.SensuNamespace = "default"
+	plugin.LabelSelector, _ = parseMatchers(`env!="dev"`)
+
+	status, err := runCheck()
+	assert.NoError(err)
+	assert.Equal(sensu.CheckStateOK, status)
+	entries := report.result()
+	if assert.Len(entries, 5) {
+		assert.Equal(actionCreate, entries[0].Action)
+		assert.Equal("NodeDown", entries[0].Event.Check.Name)
+		assert.Equal(actionUpdate, entries[1].Action)
+		assert.Equal("TargetDown", entries[1].Event.Check.Name)
+		assert.Equal(actionClose, entries[2].Action)
+		assert.Equal("PodDown", entries[2].Event.Check.Name)
+		assert.Equal(uint32(0), entries[2].Event.Check.Status)
+		assert.Equal(actionSkip, entries[3].Action)
+		assert.Equal("DiskFull", entries[3].Alert)
+		assert.Equal(filterReasonSelector, entries[3].Reason)
+		assert.Equal(actionSkip, entries[4].Action)
+		assert.Equal("Watchdog", entries[4].Alert)
+		assert.Equal(filterReasonExcludedAlertname, entries[4].Reason)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(report.print(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 6)
+	assert.Equal([]string{"ACTION", "ENTITY", "CHECK", "STATUS", "REASON"}, strings.Fields(lines[0]))
+	assert.Equal([]string{"skip", "-", "Watchdog", "-", "excluded_alertname"}, strings.Fields(lines[5]))
+
+	plugin.DryRunFormat = "json"
+	buf.Reset()
+	assert.NoError(report.print(&buf))
+	decoded := []DryRunEntry{}
+	assert.NoError(json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Len(decoded, 5)
+
+	report.reset()
+	plugin.SensuNamespace = ""
+	plugin.LabelSelector = nil
+	plugin.DryRun = false
+	plugin.DryRunFormat = ""
+	plugin.Sources = nil
+	plugin.AlertmanagerExcludeAlerts = ""
+	plugin.SensuAutoClose = false
+	plugin.APIBackendKey = ""
+	plugin.AgentAPIURL = ""
+}
+
+func TestDryRunReportDisabled(t *testing.T) {
+	report.reset()
+	skipAlert(testAlert("a", "TargetDown", time.Now()), filterReasonSelector)
+	assert.Empty(t, report.result())
+}
@@ -3,11 +3,14 @@ module github.com/betorvs/sensu-alertmanager-events
 go 1.16
 
 require (
-	github.com/pelletier/go-toml v1.7.0 // indirect
-	github.com/prometheus/alertmanager v0.21.0
+	github.com/go-openapi/strfmt v0.20.1
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/prometheus/alertmanager v0.22.2
+	github.com/prometheus/client_golang v1.10.0
+	github.com/prometheus/common v0.23.0
 	github.com/sensu-community/sensu-plugin-sdk v0.11.0
 	github.com/sensu/sensu-go/api/core/v2 v2.4.0
 	github.com/sensu/sensu-go/types v0.3.0
-	github.com/stretchr/testify v1.6.0
-	go.mongodb.org/mongo-driver v1.3.3 // indirect
+	github.com/stretchr/testify v1.7.0
+	gopkg.in/yaml.v2 v2.4.0
 )
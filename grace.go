@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// GraceState is saved in --auto-close-state-file with how many consecutive runs each event was not found in alert manager
+type GraceState struct {
+	MissingRuns map[string]int `json:"missing_runs"`
+}
+
+// graceTracker counts consecutive missing runs for events which can be closed.
+// Counts are kept in memory (daemon mode) and in --auto-close-state-file when it is configured
+type graceTracker struct {
+	sync.Mutex
+	previous map[string]int
+	current  map[string]int
+	counts   map[string]int
+}
+
+var grace = &graceTracker{counts: map[string]int{}}
+
+// start a new auto close run loading counts from --auto-close-state-file
+func (g *graceTracker) begin() error {
+	g.Lock()
+	defer g.Unlock()
+	g.previous = g.counts
+	g.current = map[string]int{}
+	if plugin.AutoCloseStateFile == "" {
+		return nil
+	}
+	body, err := ioutil.ReadFile(plugin.AutoCloseStateFile)
+	if os.IsNotExist(err) {
+		g.previous = map[string]int{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read auto close state file %s: %v", plugin.AutoCloseStateFile, err)
+	}
+	state := GraceState{}
+	err = json.Unmarshal(body, &state)
+	if err != nil {
+		return fmt.Errorf("cannot decode auto close state file %s: %v", plugin.AutoCloseStateFile, err)
+	}
+	g.previous = state.MissingRuns
+	if g.previous == nil {
+		g.previous = map[string]int{}
+	}
+	return nil
+}
+
+// finish the auto close run keeping only events missing in this run. Nothing is saved in dry run
+func (g *graceTracker) end() error {
+	g.Lock()
+	defer g.Unlock()
+	if plugin.DryRun {
+		return nil
+	}
+	g.counts = g.current
+	if plugin.AutoCloseStateFile == "" {
+		return nil
+	}
+	body, _ := json.Marshal(GraceState{MissingRuns: g.counts})
+	err := writeFileAtomic(plugin.AutoCloseStateFile, body)
+	if err != nil {
+		return fmt.Errorf("cannot write auto close state file %s: %v", plugin.AutoCloseStateFile, err)
+	}
+	return nil
+}
+
+// count one more missing run for event and return why it cannot be closed yet.
+// An empty reason means --auto-close-grace-period and --auto-close-missing-runs are satisfied
+func (g *graceTracker) wait(e *v2.Event, fingerprint string) string {
+	key := graceKey(e, fingerprint)
+	g.Lock()
+	if g.current == nil {
+		g.current = map[string]int{}
+	}
+	runs := g.previous[key] + 1
+	g.current[key] = runs
+	g.Unlock()
+	if runs < plugin.AutoCloseMissingRuns {
+		return fmt.Sprintf("missing runs %d of %d", runs, plugin.AutoCloseMissingRuns)
+	}
+	// alerts found in alert manager are sent in each run, so event timestamp is the last time alert was seen
+	period := time.Duration(plugin.AutoCloseGracePeriod) * time.Second
+	if period > 0 && e.Timestamp > 0 {
+		missing := time.Since(time.Unix(e.Timestamp, 0)).Truncate(time.Second)
+		if missing < period {
+			return fmt.Sprintf("grace period %s of %s", missing, period)
+		}
+	}
+	return ""
+}
+
+func graceKey(e *v2.Event, fingerprint string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", e.Check.Namespace, eventEntityName(e), e.Check.Name, fingerprint)
+}
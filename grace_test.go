@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraceTrackerMissingRuns(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "grace")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	plugin.AutoCloseStateFile = filepath.Join(dir, "state.json")
+	plugin.AutoCloseMissingRuns = 3
+	event := testSensuEvent("PodDown", "z")
+
+	for run := 1; run <= 3; run++ {
+		grace = &graceTracker{counts: map[string]int{}}
+		assert.NoError(grace.begin())
+		reason := grace.wait(event, "z")
+		if run < 3 {
+			assert.NotEmpty(reason)
+		} else {
+			assert.Empty(reason)
+		}
+		assert.NoError(grace.end())
+	}
+	body, err := ioutil.ReadFile(plugin.AutoCloseStateFile)
+	assert.NoError(err)
+	state := GraceState{}
+	assert.NoError(json.Unmarshal(body, &state))
+	assert.Equal(map[string]int{graceKey(event, "z"): 3}, state.MissingRuns)
+
+	// alert found again: count is removed in the next run
+	assert.NoError(grace.begin())
+	assert.NoError(grace.end())
+	body, _ = ioutil.ReadFile(plugin.AutoCloseStateFile)
+	assert.JSONEq(`{"missing_runs":{}}`, string(body))
+
+	// dry run doesn't change counts
+	plugin.DryRun = true
+	assert.NoError(grace.begin())
+	assert.NotEmpty(grace.wait(event, "z"))
+	assert.NoError(grace.end())
+	body, _ = ioutil.ReadFile(plugin.AutoCloseStateFile)
+	assert.JSONEq(`{"missing_runs":{}}`, string(body))
+
+	assert.NoError(ioutil.WriteFile(plugin.AutoCloseStateFile, []byte("{"), 0600))
+	assert.Error(grace.begin())
+
+	grace = &graceTracker{counts: map[string]int{}}
+	plugin.DryRun = false
+	plugin.AutoCloseStateFile = ""
+	plugin.AutoCloseMissingRuns = 0
+}
+
+func TestGraceTrackerGracePeriod(t *testing.T) {
+	assert := assert.New(t)
+	plugin.AutoCloseGracePeriod = 300
+	event := testSensuEvent("PodDown", "z")
+	event.Timestamp = time.Now().Add(-time.Minute).Unix()
+	assert.NoError(grace.begin())
+	assert.Contains(grace.wait(event, "z"), "grace period")
+	event.Timestamp = time.Now().Add(-10 * time.Minute).Unix()
+	assert.Empty(grace.wait(event, "z"))
+	assert.NoError(grace.end())
+	grace = &graceTracker{counts: map[string]int{}}
+	plugin.AutoCloseGracePeriod = 0
+}
+
+func TestProcessSensuEventsToCloseGrace(t *testing.T) {
+	assert := assert.New(t)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("event must not be closed in the first run: %s", r.URL.Path)
+	}))
+	defer agent.Close()
+	plugin.AgentAPIURL = agent.URL
+	plugin.AutoCloseMissingRuns = 2
+	alerts := []models.GettableAlert{testAlert("a", "TargetDown", time.Now())}
+	events := []*v2.Event{testSensuEvent("TargetDown", "a"), testSensuEvent("PodDown", "z")}
+
+	assert.NoError(grace.begin())
+	assert.Equal(0, processSensuEventsToClose(events, alerts))
+	assert.NoError(grace.end())
+
+	plugin.DryRun = true
+	report.reset()
+	assert.NoError(grace.begin())
+	assert.Equal(0, processSensuEventsToClose(events, alerts))
+	assert.NoError(grace.end())
+	entries := report.result()
+	if assert.Len(entries, 1) {
+		assert.Equal(actionClose, entries[0].Action)
+		assert.Equal("PodDown", entries[0].Event.Check.Name)
+	}
+
+	report.reset()
+	grace = &graceTracker{counts: map[string]int{}}
+	plugin.AgentAPIURL = ""
+	plugin.DryRun = false
+	plugin.AutoCloseMissingRuns = 0
+}
+
+func TestCheckArgsMissingRunsStateFile(t *testing.T) {
+	assert := assert.New(t)
+	plugin.AutoCloseMissingRuns = 3
+	plugin.Mode = "check"
+	status, err := checkArgs(nil)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "--auto-close-state-file")
+	}
+	assert.Equal(sensu.CheckStateWarning, status)
+
+	plugin.AutoCloseStateFile = filepath.Join(os.TempDir(), "state.json")
+	_, err = checkArgs(nil)
+	assert.NoError(err)
+
+	// daemon mode keeps counts in memory
+	plugin.AutoCloseStateFile = ""
+	plugin.Mode = "daemon"
+	plugin.DaemonInterval = 60
+	_, err = checkArgs(nil)
+	assert.NoError(err)
+
+	plugin.Mode = ""
+	plugin.DaemonInterval = 0
+	plugin.AutoCloseMissingRuns = 0
+}
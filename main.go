@@ -9,12 +9,20 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
+	"github.com/go-openapi/strfmt"
 	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/common/model"
 	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	v2 "github.com/sensu/sensu-go/api/core/v2"
 	"github.com/sensu/sensu-go/types"
@@ -24,13 +32,39 @@ import (
 type Config struct {
 	sensu.PluginConfig
 	AlertmanagerAPIURL          string
+	AlertmanagerSources         string
+	Sources                     []AlertmanagerSource
 	AgentAPIURL                 string
+	Delivery                    string
+	DryRun                      bool
+	DryRunFormat                string
+	Concurrency                 int
+	RateLimit                   float64
+	Retries                     int
+	RetryBackoff                int
+	RetryMaxBackoff             int
+	CircuitBreakerThreshold     int
+	AgentSocketAddress          string
 	AlertmanagerExcludeAlerts   string
 	AlertmanagerExternalURL     string
 	AlertmanagerLabelEntity     string
 	AlertmanagerLabelSelectors  string
 	AlertmanagerExcludeLabels   string
 	AlertmanagerTargetAlertname string
+	AlertmanagerActive          bool
+	AlertmanagerSilenced        bool
+	AlertmanagerInhibited       bool
+	AlertmanagerUnprocessed     bool
+	AlertmanagerReceiver        string
+	AlertmanagerUser            string
+	AlertmanagerPass            string
+	AlertmanagerBearerToken     string
+	AlertmanagerBearerTokenFile string
+	AlertmanagerHeaders         string
+	AlertmanagerCertFile        string
+	AlertmanagerKeyFile         string
+	AlertmanagerTrustedCAFile   string
+	AlertmanagerInsecure        bool
 	SensuProxyEntity            string
 	SensuAgentEntity            string
 	SensuNamespace              string
@@ -40,9 +74,16 @@ type Config struct {
 	RewriteAnnotation           string
 	SensuAutoClose              bool
 	SensuAutoCloseLabel         string
+	AutoCloseNamespaces         string
+	InstanceID                  string
+	AutoCloseGracePeriod        int
+	AutoCloseMissingRuns        int
+	AutoCloseStateFile          string
 	APIBackendPass              string
 	APIBackendUser              string
 	APIBackendKey               string
+	APIBackendPageSize          int
+	APIBackendTokenFile         string
 	APIBackendHost              string
 	APIBackendPort              int
 	Secure                      bool
@@ -50,8 +91,34 @@ type Config struct {
 	InsecureSkipVerify          bool
 	Protocol                    string
 	ProxyEntity                 string
-	LabelSelector               map[string]string
-	ExcludeLabels               map[string]string
+	CheckNameTemplate           string
+	ProxyEntityTemplate         string
+	CheckNameTmpl               *template.Template
+	ProxyEntityTmpl             *template.Template
+	RelabelConfigsText          string
+	RelabelConfigsFile          string
+	RelabelConfigs              []RelabelConfig
+	OutputFormat                string
+	OutputTemplate              string
+	OutputTmpl                  *template.Template
+	LabelSelector               []*labels.Matcher
+	ExcludeLabels               []*labels.Matcher
+	AlertmanagerSeverityLabel   string
+	AlertmanagerSeverityStatus  string
+	AlertmanagerSeverityDefault string
+	SeverityStatus              map[string]string
+	WebhookListenAddress        string
+	WebhookPath                 string
+	DaemonListenAddress         string
+	DaemonInterval              int
+	Mode                        string
+}
+
+// AlertmanagerSource represents one Alert Manager instance and its static labels
+type AlertmanagerSource struct {
+	Name   string            `json:"name"`
+	URL    string            `json:"url"`
+	Labels map[string]string `json:"labels"`
 }
 
 // Auth represents the authentication info
@@ -61,6 +128,15 @@ type Auth struct {
 	ExpiresAt    int64  `json:"expires_at"`
 }
 
+// sourceLabel is added in alerts from named alert manager sources
+const sourceLabel = "alertmanager_source"
+
+// outputFormats are the check output formats available in --output-format
+var outputFormats = []string{"plain", "markdown", "json", "summary"}
+
+// severitySkip is used in severity mapping to not send alerts to sensu
+const severitySkip = "skip"
+
 var (
 	tlsConfig tls.Config
 
@@ -79,9 +155,18 @@ var (
 			Argument:  "alert-manager-api-url",
 			Shorthand: "a",
 			Default:   "http://alertmanager-main.monitoring:9093/api/v2/alerts",
-			Usage:     "The URL for the Agent to connect to Alert Manager",
+			Usage:     "The URL for the Agent to connect to Alert Manager, a file:// path or - for stdin with a /api/v2/alerts response. For multiple Alert Manager instances split by comma",
 			Value:     &plugin.AlertmanagerAPIURL,
 		},
+		{
+			Path:      "alert-manager-sources",
+			Env:       "ALERT_MANAGER_SOURCES",
+			Argument:  "alert-manager-sources",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Named Alert Manager instances with static labels added in each alert. It replaces --alert-manager-api-url. e. [{\"name\":\"prod-eu\",\"url\":\"http://alertmanager.prod-eu:9093/api/v2/alerts\",\"labels\":{\"cluster\":\"prod-eu\"}}]",
+			Value:     &plugin.AlertmanagerSources,
+		},
 		{
 			Path:      "agent-api-url",
 			Env:       "AGENT_API_URL",
@@ -91,6 +176,96 @@ var (
 			Usage:     "The URL for the Agent API used to send events",
 			Value:     &plugin.AgentAPIURL,
 		},
+		{
+			Path:      "delivery",
+			Env:       "DELIVERY",
+			Argument:  "delivery",
+			Shorthand: "",
+			Default:   "agent",
+			Usage:     "Where events are sent: agent (uses --agent-api-url), backend (uses api-backend-* flags), tcp or udp (uses --agent-socket-address)",
+			Value:     &plugin.Delivery,
+		},
+		{
+			Path:      "dry-run",
+			Env:       "",
+			Argument:  "dry-run",
+			Shorthand: "",
+			Default:   false,
+			Usage:     "Print events which would be created and closed without sending them to Sensu",
+			Value:     &plugin.DryRun,
+		},
+		{
+			Path:      "dry-run-format",
+			Env:       "DRY_RUN_FORMAT",
+			Argument:  "dry-run-format",
+			Shorthand: "",
+			Default:   "table",
+			Usage:     "Dry run report format: table or json",
+			Value:     &plugin.DryRunFormat,
+		},
+		{
+			Path:      "concurrency",
+			Env:       "CONCURRENCY",
+			Argument:  "concurrency",
+			Shorthand: "",
+			Default:   defaultConcurrency,
+			Usage:     "Maximum number of events posted to Sensu at the same time",
+			Value:     &plugin.Concurrency,
+		},
+		{
+			Path:      "rate-limit",
+			Env:       "RATE_LIMIT",
+			Argument:  "rate-limit",
+			Shorthand: "",
+			Default:   float64(0),
+			Usage:     "Maximum number of events posted to Sensu per second. 0 means no limit",
+			Value:     &plugin.RateLimit,
+		},
+		{
+			Path:      "retries",
+			Env:       "RETRIES",
+			Argument:  "retries",
+			Shorthand: "",
+			Default:   3,
+			Usage:     "Number of retries for Sensu Agent API posts and Sensu Backend API requests after connection errors or 429, 502, 503 and 504 responses",
+			Value:     &plugin.Retries,
+		},
+		{
+			Path:      "retry-backoff",
+			Env:       "RETRY_BACKOFF",
+			Argument:  "retry-backoff",
+			Shorthand: "",
+			Default:   500,
+			Usage:     "Initial backoff in milliseconds between retries. It doubles in each retry",
+			Value:     &plugin.RetryBackoff,
+		},
+		{
+			Path:      "retry-max-backoff",
+			Env:       "RETRY_MAX_BACKOFF",
+			Argument:  "retry-max-backoff",
+			Shorthand: "",
+			Default:   10000,
+			Usage:     "Maximum backoff in milliseconds between retries, including Retry-After responses",
+			Value:     &plugin.RetryMaxBackoff,
+		},
+		{
+			Path:      "circuit-breaker-threshold",
+			Env:       "CIRCUIT_BREAKER_THRESHOLD",
+			Argument:  "circuit-breaker-threshold",
+			Shorthand: "",
+			Default:   5,
+			Usage:     "Consecutive failures after which an endpoint is not called again in the same run. 0 disables it",
+			Value:     &plugin.CircuitBreakerThreshold,
+		},
+		{
+			Path:      "agent-socket-address",
+			Env:       "AGENT_SOCKET_ADDRESS",
+			Argument:  "agent-socket-address",
+			Shorthand: "",
+			Default:   "127.0.0.1:3030",
+			Usage:     "The address of Sensu Agent socket used to send events with --delivery tcp or udp",
+			Value:     &plugin.AgentSocketAddress,
+		},
 		{
 			Path:      "alert-manager-exclude-alert-list",
 			Env:       "ALERT_MANAGER_EXCLUDE_ALERT_LIST",
@@ -124,7 +299,7 @@ var (
 			Argument:  "alert-manager-label-selectors",
 			Shorthand: "l",
 			Default:   "",
-			Usage:     "Query for Alertmanager LabelSelectors (e.g. alertname=TargetDown,environment=dev)",
+			Usage:     "Query for Alertmanager LabelSelectors using Alert Manager matchers. All must match (e.g. alertname=TargetDown,environment=dev or {severity=~\"critical|warning\",namespace!~\"kube-.*\"})",
 			Value:     &plugin.AlertmanagerLabelSelectors,
 		},
 		{
@@ -133,7 +308,7 @@ var (
 			Argument:  "alert-manager-exclude-labels",
 			Shorthand: "L",
 			Default:   "",
-			Usage:     "Query for Alertmanager Exclude Labels (e.g. alertname=TargetDown,environment=dev)",
+			Usage:     "Query for Alertmanager Exclude Labels using Alert Manager matchers. Any match excludes the alert (e.g. alertname=TargetDown,environment=dev or {namespace=~\"kube-.*\"})",
 			Value:     &plugin.AlertmanagerExcludeLabels,
 		},
 		{
@@ -145,6 +320,78 @@ var (
 			Usage:     "Alert name for Targets in prometheus. It creates a link in label prometheus_targets_url",
 			Value:     &plugin.AlertmanagerTargetAlertname,
 		},
+		{
+			Path:      "alert-manager-active",
+			Env:       "ALERT_MANAGER_ACTIVE",
+			Argument:  "alert-manager-active",
+			Shorthand: "",
+			Default:   true,
+			Usage:     "Query active alerts from Alert Manager API",
+			Value:     &plugin.AlertmanagerActive,
+		},
+		{
+			Path:      "alert-manager-silenced",
+			Env:       "ALERT_MANAGER_SILENCED",
+			Argument:  "alert-manager-silenced",
+			Shorthand: "",
+			Default:   true,
+			Usage:     "Query silenced alerts from Alert Manager API. They are not sent to Sensu but they avoid auto close",
+			Value:     &plugin.AlertmanagerSilenced,
+		},
+		{
+			Path:      "alert-manager-inhibited",
+			Env:       "ALERT_MANAGER_INHIBITED",
+			Argument:  "alert-manager-inhibited",
+			Shorthand: "",
+			Default:   true,
+			Usage:     "Query inhibited alerts from Alert Manager API. They are not sent to Sensu but they avoid auto close",
+			Value:     &plugin.AlertmanagerInhibited,
+		},
+		{
+			Path:      "alert-manager-unprocessed",
+			Env:       "ALERT_MANAGER_UNPROCESSED",
+			Argument:  "alert-manager-unprocessed",
+			Shorthand: "",
+			Default:   true,
+			Usage:     "Query unprocessed alerts from Alert Manager API. They are not sent to Sensu but they avoid auto close",
+			Value:     &plugin.AlertmanagerUnprocessed,
+		},
+		{
+			Path:      "alert-manager-receiver",
+			Env:       "ALERT_MANAGER_RECEIVER",
+			Argument:  "alert-manager-receiver",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Query only alerts from Alert Manager receivers matching this regex",
+			Value:     &plugin.AlertmanagerReceiver,
+		},
+		{
+			Path:      "alert-manager-severity-label",
+			Env:       "ALERT_MANAGER_SEVERITY_LABEL",
+			Argument:  "alert-manager-severity-label",
+			Shorthand: "",
+			Default:   "severity",
+			Usage:     "Alert Manager label used to define Sensu check status",
+			Value:     &plugin.AlertmanagerSeverityLabel,
+		},
+		{
+			Path:      "alert-manager-severity-status",
+			Env:       "ALERT_MANAGER_SEVERITY_STATUS",
+			Argument:  "alert-manager-severity-status",
+			Shorthand: "",
+			Default:   "critical=2,warning=1,info=0,none=0",
+			Usage:     "Map severity label values to Sensu check status (0, 1, 2, 3 or skip to not send it). Format: severity=status,severity=status",
+			Value:     &plugin.AlertmanagerSeverityStatus,
+		},
+		{
+			Path:      "alert-manager-severity-default",
+			Env:       "ALERT_MANAGER_SEVERITY_DEFAULT",
+			Argument:  "alert-manager-severity-default",
+			Shorthand: "",
+			Default:   "2",
+			Usage:     "Sensu check status used when severity label is missing or not mapped (0, 1, 2, 3 or skip)",
+			Value:     &plugin.AlertmanagerSeverityDefault,
+		},
 		{
 			Path:      "sensu-proxy-entity",
 			Env:       "SENSU_PROXY_ENTITY",
@@ -154,6 +401,42 @@ var (
 			Usage:     "Overwrite Proxy Entity in Sensu",
 			Value:     &plugin.SensuProxyEntity,
 		},
+		{
+			Path:      "check-name-template",
+			Env:       "CHECK_NAME_TEMPLATE",
+			Argument:  "check-name-template",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Go template used to create Sensu check name from alert. e. {{ .Labels.alertname }}-{{ .Labels.instance | trimPort | sanitize }}",
+			Value:     &plugin.CheckNameTemplate,
+		},
+		{
+			Path:      "proxy-entity-template",
+			Env:       "PROXY_ENTITY_TEMPLATE",
+			Argument:  "proxy-entity-template",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Go template used to create Sensu proxy entity name from alert. e. {{ .Labels.instance | trimPort | lower }}",
+			Value:     &plugin.ProxyEntityTemplate,
+		},
+		{
+			Path:      "output-format",
+			Env:       "OUTPUT_FORMAT",
+			Argument:  "output-format",
+			Shorthand: "",
+			Default:   "plain",
+			Usage:     "Sensu check output format: plain, markdown, json or summary",
+			Value:     &plugin.OutputFormat,
+		},
+		{
+			Path:      "output-template",
+			Env:       "OUTPUT_TEMPLATE",
+			Argument:  "output-template",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Go template used to create Sensu check output. It replaces --output-format. e. {{ .Annotations.summary }} ({{ .Status }})",
+			Value:     &plugin.OutputTemplate,
+		},
 		{
 			Path:      "sensu-agent-entity",
 			Env:       "HOSTNAME",
@@ -208,6 +491,24 @@ var (
 			Usage:     "Rewrite Annotation from prometheus rules to sensu annotation format to work with sensu plugins. Format: opsgenie_priority=sensu.io/plugins/sensu-opsgenie-handler/config/priority Or for multiples use comma: opsgenie_priority=sensu.io/plugins/sensu-opsgenie-handler/config/priority,extraTwo=extraValue",
 			Value:     &plugin.RewriteAnnotation,
 		},
+		{
+			Path:      "relabel-configs",
+			Env:       "RELABEL_CONFIGS",
+			Argument:  "relabel-configs",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Prometheus relabel_configs rules in YAML or JSON applied to alert labels. e. [{\"action\":\"labeldrop\",\"regex\":\"pod_template_hash|uid\"}]",
+			Value:     &plugin.RelabelConfigsText,
+		},
+		{
+			Path:      "relabel-configs-file",
+			Env:       "RELABEL_CONFIGS_FILE",
+			Argument:  "relabel-configs-file",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "File with Prometheus relabel_configs rules in YAML or JSON applied to alert labels",
+			Value:     &plugin.RelabelConfigsFile,
+		},
 		{
 			Path:      "auto-close-sensu",
 			Env:       "",
@@ -226,6 +527,51 @@ var (
 			Usage:     "Configure it to Auto Close if event doesn't match any Alerts from Alert Manager and with these label. e. {\"cluster\":\"k8s-dev\"}",
 			Value:     &plugin.SensuAutoCloseLabel,
 		},
+		{
+			Path:      "auto-close-namespaces",
+			Env:       "AUTO_CLOSE_NAMESPACES",
+			Argument:  "auto-close-namespaces",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Sensu Namespaces used by auto close split by comma, or * for all namespaces. Default is --sensu-namespace. Requires --delivery backend",
+			Value:     &plugin.AutoCloseNamespaces,
+		},
+		{
+			Path:      "instance-id",
+			Env:       "INSTANCE_ID",
+			Argument:  "instance-id",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Added as label sensu-alertmanager-events-instance in all events. Auto close only closes events from the same instance",
+			Value:     &plugin.InstanceID,
+		},
+		{
+			Path:      "auto-close-grace-period",
+			Env:       "AUTO_CLOSE_GRACE_PERIOD",
+			Argument:  "auto-close-grace-period",
+			Shorthand: "",
+			Default:   0,
+			Usage:     "Seconds since an alert was last seen in Alert Manager before its event is closed",
+			Value:     &plugin.AutoCloseGracePeriod,
+		},
+		{
+			Path:      "auto-close-missing-runs",
+			Env:       "AUTO_CLOSE_MISSING_RUNS",
+			Argument:  "auto-close-missing-runs",
+			Shorthand: "",
+			Default:   1,
+			Usage:     "Consecutive runs an alert should be missing in Alert Manager before its event is closed",
+			Value:     &plugin.AutoCloseMissingRuns,
+		},
+		{
+			Path:      "auto-close-state-file",
+			Env:       "AUTO_CLOSE_STATE_FILE",
+			Argument:  "auto-close-state-file",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "File used to keep --auto-close-missing-runs counts between check executions. Counts are kept in memory without it",
+			Value:     &plugin.AutoCloseStateFile,
+		},
 		{
 			Path:      "api-backend-user",
 			Env:       "SENSU_API_USER",
@@ -253,6 +599,24 @@ var (
 			Usage:     "Sensu Go Backend API Key",
 			Value:     &plugin.APIBackendKey,
 		},
+		{
+			Path:      "api-backend-token-file",
+			Env:       "SENSU_API_TOKEN_FILE",
+			Argument:  "api-backend-token-file",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "File used to cache Sensu Go Backend API access token between executions (saved with 0600 permissions)",
+			Value:     &plugin.APIBackendTokenFile,
+		},
+		{
+			Path:      "api-backend-page-size",
+			Env:       "SENSU_API_PAGE_SIZE",
+			Argument:  "api-backend-page-size",
+			Shorthand: "",
+			Default:   500,
+			Usage:     "Number of events requested in each page from Sensu Backend API. 0 disables pagination",
+			Value:     &plugin.APIBackendPageSize,
+		},
 		{
 			Path:      "api-backend-host",
 			Env:       "",
@@ -298,14 +662,148 @@ var (
 			Usage:     "TLS CA certificate bundle in PEM format",
 			Value:     &plugin.TrustedCAFile,
 		},
+		{
+			Path:      "alert-manager-user",
+			Env:       "ALERT_MANAGER_USER",
+			Argument:  "alert-manager-user",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Alert Manager basic auth user",
+			Value:     &plugin.AlertmanagerUser,
+		},
+		{
+			Path:      "alert-manager-pass",
+			Env:       "ALERT_MANAGER_PASSWORD",
+			Argument:  "alert-manager-pass",
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "Alert Manager basic auth password",
+			Value:     &plugin.AlertmanagerPass,
+		},
+		{
+			Path:      "alert-manager-bearer-token",
+			Env:       "ALERT_MANAGER_BEARER_TOKEN",
+			Argument:  "alert-manager-bearer-token",
+			Shorthand: "",
+			Default:   "",
+			Secret:    true,
+			Usage:     "Alert Manager bearer token",
+			Value:     &plugin.AlertmanagerBearerToken,
+		},
+		{
+			Path:      "alert-manager-bearer-token-file",
+			Env:       "ALERT_MANAGER_BEARER_TOKEN_FILE",
+			Argument:  "alert-manager-bearer-token-file",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "File with Alert Manager bearer token. It is read in each request",
+			Value:     &plugin.AlertmanagerBearerTokenFile,
+		},
+		{
+			Path:      "alert-manager-headers",
+			Env:       "ALERT_MANAGER_HEADERS",
+			Argument:  "alert-manager-headers",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "Extra HTTP headers sent to Alert Manager. Format: Header=Value Or for multiples use comma: X-Scope-OrgID=tenant1,Header=Value",
+			Value:     &plugin.AlertmanagerHeaders,
+		},
+		{
+			Path:      "alert-manager-cert-file",
+			Env:       "ALERT_MANAGER_CERT_FILE",
+			Argument:  "alert-manager-cert-file",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "TLS client certificate in PEM format used to connect to Alert Manager",
+			Value:     &plugin.AlertmanagerCertFile,
+		},
+		{
+			Path:      "alert-manager-key-file",
+			Env:       "ALERT_MANAGER_KEY_FILE",
+			Argument:  "alert-manager-key-file",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "TLS client key in PEM format used to connect to Alert Manager",
+			Value:     &plugin.AlertmanagerKeyFile,
+		},
+		{
+			Path:      "alert-manager-trusted-ca-file",
+			Env:       "ALERT_MANAGER_TRUSTED_CA_FILE",
+			Argument:  "alert-manager-trusted-ca-file",
+			Shorthand: "",
+			Default:   "",
+			Usage:     "TLS CA certificate bundle in PEM format used to connect to Alert Manager",
+			Value:     &plugin.AlertmanagerTrustedCAFile,
+		},
+		{
+			Path:      "alert-manager-insecure-skip-verify",
+			Env:       "",
+			Argument:  "alert-manager-insecure-skip-verify",
+			Shorthand: "",
+			Default:   false,
+			Usage:     "skip Alert Manager TLS certificate verification (not recommended!)",
+			Value:     &plugin.AlertmanagerInsecure,
+		},
+		{
+			Path:      "webhook-listen-address",
+			Env:       "WEBHOOK_LISTEN_ADDRESS",
+			Argument:  "webhook-listen-address",
+			Shorthand: "",
+			Default:   ":9099",
+			Usage:     "Address used to receive Alert Manager webhooks in serve mode",
+			Value:     &plugin.WebhookListenAddress,
+		},
+		{
+			Path:      "webhook-path",
+			Env:       "WEBHOOK_PATH",
+			Argument:  "webhook-path",
+			Shorthand: "",
+			Default:   "/webhook",
+			Usage:     "HTTP path used to receive Alert Manager webhooks in serve mode",
+			Value:     &plugin.WebhookPath,
+		},
+		{
+			Path:      "daemon-listen-address",
+			Env:       "DAEMON_LISTEN_ADDRESS",
+			Argument:  "daemon-listen-address",
+			Shorthand: "",
+			Default:   ":9099",
+			Usage:     "Address used by /healthz and /readyz endpoints in daemon mode",
+			Value:     &plugin.DaemonListenAddress,
+		},
+		{
+			Path:      "daemon-interval",
+			Env:       "DAEMON_INTERVAL",
+			Argument:  "daemon-interval",
+			Shorthand: "",
+			Default:   60,
+			Usage:     "Interval in seconds between each Alert Manager poll in daemon mode",
+			Value:     &plugin.DaemonInterval,
+		},
 	}
 )
 
 func main() {
+	plugin.Mode = parseMode()
 	check := sensu.NewGoCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, false)
 	check.Execute()
 }
 
+// parseMode removes the running mode from command line arguments
+// sensu plugin sdk doesn't allow us to add extra sub commands
+func parseMode() string {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve", "daemon":
+			mode := os.Args[1]
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			return mode
+		}
+	}
+	return "check"
+}
+
 func checkArgs(event *types.Event) (int, error) {
 	if plugin.AlertmanagerLabelEntity != "" && plugin.SensuProxyEntity != "" {
 		return sensu.CheckStateWarning, fmt.Errorf("Cannot use --alert-manager-cluster-label-entity %s and --sensu-proxy-entity %s together", plugin.AlertmanagerLabelEntity, plugin.SensuProxyEntity)
@@ -320,13 +818,97 @@ func checkArgs(event *types.Event) (int, error) {
 	if plugin.SensuProxyEntity != "" {
 		plugin.ProxyEntity = "SensuProxyEntity"
 	}
+	// or create proxy entity name from alert using a template
+	if plugin.ProxyEntityTemplate != "" {
+		if plugin.ProxyEntity != "KubernetesResource" {
+			return sensu.CheckStateWarning, fmt.Errorf("Cannot use --proxy-entity-template with --alert-manager-cluster-label-entity or --sensu-proxy-entity")
+		}
+		tmpl, err := parseTemplate("proxy-entity", plugin.ProxyEntityTemplate)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Wrong template --proxy-entity-template %s: %v", plugin.ProxyEntityTemplate, err)
+		}
+		plugin.ProxyEntityTmpl = tmpl
+		plugin.ProxyEntity = "ProxyEntityTemplate"
+	}
+	if plugin.Delivery != "" && !stringInSlice(plugin.Delivery, deliveryModes) {
+		return sensu.CheckStateWarning, fmt.Errorf("Please use one of %s. Wrong value --delivery %s", strings.Join(deliveryModes, ", "), plugin.Delivery)
+	}
+	// socket results don't have labels, events created by them can't be matched by fingerprint
+	if (plugin.Delivery == deliveryTCP || plugin.Delivery == deliveryUDP) && plugin.SensuAutoClose {
+		return sensu.CheckStateWarning, fmt.Errorf("--auto-close-sensu cannot be used with --delivery %s, labels are not sent to Sensu Agent socket", plugin.Delivery)
+	}
+
+	if plugin.DryRunFormat != "" && !stringInSlice(plugin.DryRunFormat, dryRunFormats) {
+		return sensu.CheckStateWarning, fmt.Errorf("Please use one of %s. Wrong value --dry-run-format %s", strings.Join(dryRunFormats, ", "), plugin.DryRunFormat)
+	}
+	if plugin.AutoCloseNamespaces != "" && plugin.Delivery != deliveryBackend {
+		return sensu.CheckStateWarning, fmt.Errorf("--auto-close-namespaces requires --delivery backend, events can only be closed in sensu agent namespace")
+	}
+	if plugin.AutoCloseGracePeriod < 0 || plugin.AutoCloseMissingRuns < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--auto-close-grace-period and --auto-close-missing-runs should be greater or equal to 0")
+	}
+	// a scheduled check starts from zero every execution, missing runs would never add up
+	if plugin.Mode == "check" && plugin.AutoCloseMissingRuns > 1 && plugin.AutoCloseStateFile == "" {
+		return sensu.CheckStateWarning, fmt.Errorf("--auto-close-missing-runs %d requires --auto-close-state-file when running as a check, counts are lost between executions", plugin.AutoCloseMissingRuns)
+	}
+	if plugin.APIBackendPageSize < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--api-backend-page-size should be greater or equal to 0")
+	}
+	if plugin.Concurrency < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--concurrency should be greater or equal to 0 (0 uses the default)")
+	}
+	if plugin.RateLimit < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--rate-limit should be greater or equal to 0")
+	}
+	setPostLimiter(plugin.Concurrency, plugin.RateLimit)
+	if plugin.Retries < 0 || plugin.RetryBackoff < 0 || plugin.RetryMaxBackoff < 0 || plugin.CircuitBreakerThreshold < 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--retries, --retry-backoff, --retry-max-backoff and --circuit-breaker-threshold should be greater or equal to 0")
+	}
+
+	relabelConfigs, err := loadRelabelConfigs()
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	plugin.RelabelConfigs = relabelConfigs
+
+	if plugin.OutputFormat != "" && !stringInSlice(plugin.OutputFormat, outputFormats) {
+		return sensu.CheckStateWarning, fmt.Errorf("Please use one of %s. Wrong value --output-format %s", strings.Join(outputFormats, ", "), plugin.OutputFormat)
+	}
+	if plugin.OutputTemplate != "" {
+		tmpl, err := parseTemplate("output", plugin.OutputTemplate)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Wrong template --output-template %s: %v", plugin.OutputTemplate, err)
+		}
+		plugin.OutputTmpl = tmpl
+	}
+	if plugin.CheckNameTemplate != "" {
+		tmpl, err := parseTemplate("check-name", plugin.CheckNameTemplate)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Wrong template --check-name-template %s: %v", plugin.CheckNameTemplate, err)
+		}
+		plugin.CheckNameTmpl = tmpl
+	}
+	// Alert Manager instances
+	sources, err := parseAlertmanagerSources()
+	if err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	plugin.Sources = sources
 	// LabelsSelectors
 	if plugin.AlertmanagerLabelSelectors != "" {
-		plugin.LabelSelector = parseLabelArg(plugin.AlertmanagerLabelSelectors)
+		matchers, err := parseMatchers(plugin.AlertmanagerLabelSelectors)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Please use Alert Manager matchers format: label=value, label!=value, label=~regex or label!~regex. Wrong format --alert-manager-label-selectors %s: %v", plugin.AlertmanagerLabelSelectors, err)
+		}
+		plugin.LabelSelector = matchers
 	}
 	// ExcludeLabels
 	if plugin.AlertmanagerExcludeLabels != "" {
-		plugin.ExcludeLabels = parseLabelArg(plugin.AlertmanagerExcludeLabels)
+		matchers, err := parseMatchers(plugin.AlertmanagerExcludeLabels)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Please use Alert Manager matchers format: label=value, label!=value, label=~regex or label!~regex. Wrong format --alert-manager-exclude-labels %s: %v", plugin.AlertmanagerExcludeLabels, err)
+		}
+		plugin.ExcludeLabels = matchers
 	}
 	// For Sensu Backend Connections
 	if plugin.Secure {
@@ -365,19 +947,62 @@ func checkArgs(event *types.Event) (int, error) {
 
 	}
 
+	if plugin.AlertmanagerReceiver != "" {
+		if _, err := regexp.Compile(plugin.AlertmanagerReceiver); err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Please use a valid regex. Wrong format --alert-manager-receiver %s: %v", plugin.AlertmanagerReceiver, err)
+		}
+	}
+	// For Alert Manager Connections
+	if err := checkAlertmanagerAuth(); err != nil {
+		return sensu.CheckStateWarning, err
+	}
+	// severity to sensu status mapping
+	var severityStatus map[string]string
+	severityStatus, err = parseSeverityStatus(plugin.AlertmanagerSeverityStatus)
+	if err != nil {
+		return sensu.CheckStateWarning, fmt.Errorf("Please use Format: severity=status. Wrong format --alert-manager-severity-status %s: %v", plugin.AlertmanagerSeverityStatus, err)
+	}
+	plugin.SeverityStatus = severityStatus
+	if plugin.AlertmanagerSeverityDefault != "" && !validSensuStatus(plugin.AlertmanagerSeverityDefault) {
+		return sensu.CheckStateWarning, fmt.Errorf("Please use 0, 1, 2, 3 or %s. Wrong value --alert-manager-severity-default %s", severitySkip, plugin.AlertmanagerSeverityDefault)
+	}
+
+	if plugin.Mode == "daemon" && plugin.DaemonInterval < 1 {
+		return sensu.CheckStateWarning, fmt.Errorf("Please use an interval greater than 0. Wrong value --daemon-interval %d", plugin.DaemonInterval)
+	}
+
+	if plugin.Mode == "serve" && !strings.HasPrefix(plugin.WebhookPath, "/") {
+		return sensu.CheckStateWarning, fmt.Errorf("Please use a path starting with /. Wrong format --webhook-path %s", plugin.WebhookPath)
+	}
+
 	return sensu.CheckStateOK, nil
 }
 
 func executeCheck(event *types.Event) (int, error) {
 	// log.Printf("executing check with %s, %s, %s", plugin.AlertmanagerAPIURL, plugin.AgentAPIURL, plugin.AlertmanagerLabelEntity)
-	alerts, err := getAlertManagerEvents()
+	switch plugin.Mode {
+	case "serve":
+		return serveWebhook()
+	case "daemon":
+		return runDaemon()
+	}
+	return runCheck()
+}
+
+// fetch alerts from alert manager, send them to sensu agent api and close resolved events
+func runCheck() (int, error) {
+	breaker.reset()
+	report.reset()
+	defer printDryRun()
+	alerts, unreachable, err := getAlertManagerEvents()
 	if err != nil {
 		return sensu.CheckStateCritical, err
 	}
-	var AlertmanagerExcludeAlertList []string
-	if strings.Contains(plugin.AlertmanagerExcludeAlerts, ",") {
-		AlertmanagerExcludeAlertList = strings.Split(plugin.AlertmanagerExcludeAlerts, ",")
+	err = prepareDelivery()
+	if err != nil {
+		return sensu.CheckStateCritical, err
 	}
+	AlertmanagerExcludeAlertList := excludeAlertList()
 	numAlerts := len(alerts)
 	log.Printf("Number of Alerts found: %d", numAlerts)
 	// create an event into sensu
@@ -397,26 +1022,37 @@ func executeCheck(event *types.Event) (int, error) {
 		defer wg.Done()
 		// Compare sensu events with alerts and resolved it
 		if plugin.SensuAutoClose {
-			var autherr error
-			auth := Auth{}
-			if len(plugin.APIBackendKey) == 0 {
-				auth, autherr = authenticate()
-
-				if autherr != nil {
-					// return sensu.CheckStateUnknown, autherr
-					results <- autherr
+			// alerts list is not complete, we cannot compare it with sensu events
+			if len(unreachable) != 0 {
+				log.Printf("Skipping auto close because alert manager alerts were not fetched from: %s", strings.Join(unreachable, ", "))
+				results <- nil
+				return
+			}
+			err := grace.begin()
+			if err != nil {
+				results <- err
+				return
+			}
+			numEvents := 0
+			for _, namespace := range autoCloseNamespaces() {
+				err = getEvents(namespace, func(events []*types.Event) error {
+					report.addExisting(events)
+					numEvents += len(events)
+					countErrorsClosing += processSensuEventsToClose(events, alerts)
+					return nil
+				})
+				if err != nil {
+					// return sensu.CheckStateCritical, err
+					results <- err
+					return
 				}
 			}
-			events, err := getEvents(auth, plugin.SensuNamespace)
+			err = grace.end()
 			if err != nil {
-				// return sensu.CheckStateCritical, err
 				results <- err
+				return
 			}
-			numEvents := len(events)
 			log.Printf("Number of Events found: %d\n", numEvents)
-			if numEvents != 0 {
-				countErrorsClosing = processSensuEventsToClose(events, alerts)
-			}
 		}
 		results <- nil
 	}()
@@ -434,129 +1070,386 @@ func executeCheck(event *types.Event) (int, error) {
 	if countErrorsClosing != 0 {
 		return sensu.CheckStateWarning, fmt.Errorf("cannot close all events in sensu backend")
 	}
+	if len(unreachable) != 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("cannot get alerts from alert manager instances: %s", strings.Join(unreachable, ", "))
+	}
 	return sensu.CheckStateOK, nil
 }
 
 func processAlertsToSensuAgent(alerts []models.GettableAlert, AlertmanagerExcludeAlertList []string) int {
-	count := 0
-	results := make(chan int, len(alerts))
-	var wg sync.WaitGroup
-	for _, a := range alerts {
-		wg.Add(1)
-		go func(a models.GettableAlert) {
-			defer wg.Done()
-			for k, v := range a.Labels {
-				if k == "alertname" && !stringInSlice(v, AlertmanagerExcludeAlertList) {
-
-					alertName, sensuAlertName, clusterName, kubernetesResource, labels, annotations := alertDetails(a)
-					output := printAlert(a, alertName)
-					var proxyEntityName string
-					switch plugin.ProxyEntity {
-					case "KubernetesResource":
-						proxyEntityName = kubernetesResource
-
-					case "AlertmanagerLabelEntity":
-						proxyEntityName = clusterName
-
-					case "SensuProxyEntity":
-						proxyEntityName = plugin.SensuProxyEntity
-
-					default:
-						proxyEntityName = kubernetesResource
-						if kubernetesResource == "" {
-							proxyEntityName = removeSpecialCharacters(alertName)
-						}
-					}
-					if *a.Status.State != "active" {
-						// if not active, don't post it to sensu
-						log.Printf("Not Sending Alert %s", a.Labels["alertname"])
-						continue
-					}
-					if plugin.SensuExtraLabel != "" {
-						extraLabels := parseLabelArg(plugin.SensuExtraLabel)
-						// log.Println(extraLabels)
-						labels = mergeStringMaps(labels, extraLabels)
-					}
-					if plugin.SensuExtraAnnotation != "" {
-						extraAnnotations := parseLabelArg(plugin.SensuExtraAnnotation)
-						// log.Println(extraAnnotations)
-						annotations = mergeStringMaps(annotations, extraAnnotations)
-					}
-					log.Printf("Sending Alert %s to %s", sensuAlertName, proxyEntityName)
-					err := sendAlertsToSensu(alertName, sensuAlertName, proxyEntityName, output, labels, annotations, 2)
-					if err != nil {
-						log.Printf("Error sending Alert %s to %s", sensuAlertName, proxyEntityName)
-						results <- 1
-						// count++
-					}
+	errs := runPool(len(alerts), func(i int) error {
+		a := alerts[i]
+		if *a.Status.State != "active" {
+			// if not active, don't post it to sensu
+			log.Printf("Not Sending Alert %s", a.Labels["alertname"])
+			skipAlert(a, filterReasonNotActive)
+			return nil
+		}
+		sensuStatus, send := alertSensuStatus(a)
+		if !send {
+			log.Printf("Skipping Alert %s by severity", a.Labels["alertname"])
+			skipAlert(a, filterReasonSeverity)
+			return nil
+		}
+		return processAlert(a, AlertmanagerExcludeAlertList, sensuStatus)
+	})
+	return errorCount(errs)
+}
 
-				}
-			}
-		}(a)
+// send one alert from alert manager to sensu agent api using sensuStatus as check status
+func processAlert(a models.GettableAlert, AlertmanagerExcludeAlertList []string, sensuStatus uint32) error {
+	if v, ok := a.Labels["alertname"]; !ok || stringInSlice(v, AlertmanagerExcludeAlertList) {
+		skipAlert(a, filterReasonExcludedAlertname)
+		return nil
+	}
+	alertName, sensuAlertName, clusterName, kubernetesResource, labels, annotations := alertDetails(a)
+	output := printAlert(a, alertName)
+	if plugin.CheckNameTmpl != nil {
+		name, err := executeTemplate(plugin.CheckNameTmpl, a)
+		if err != nil || name == "" {
+			log.Printf("Cannot use check name template for alert %s, using %s: %v", alertName, sensuAlertName, err)
+		} else {
+			sensuAlertName = name
+		}
 	}
-	wg.Wait()
-	close(results)
-	for r := range results {
-		count += r
+	var proxyEntityName string
+	switch plugin.ProxyEntity {
+	case "KubernetesResource":
+		proxyEntityName = kubernetesResource
+
+	case "AlertmanagerLabelEntity":
+		proxyEntityName = clusterName
+
+	case "SensuProxyEntity":
+		proxyEntityName = plugin.SensuProxyEntity
+
+	case "ProxyEntityTemplate":
+		name, err := executeTemplate(plugin.ProxyEntityTmpl, a)
+		if err != nil || name == "" {
+			log.Printf("Cannot use proxy entity template for alert %s, using %s: %v", alertName, kubernetesResource, err)
+			name = kubernetesResource
+		}
+		proxyEntityName = name
+
+	default:
+		proxyEntityName = kubernetesResource
+		if kubernetesResource == "" {
+			proxyEntityName = removeSpecialCharacters(alertName)
+		}
+	}
+	if plugin.SensuExtraLabel != "" {
+		extraLabels := renderTemplateValues(parseLabelArg(plugin.SensuExtraLabel), a)
+		// log.Println(extraLabels)
+		labels = mergeStringMaps(labels, extraLabels)
+	}
+	if plugin.SensuExtraAnnotation != "" {
+		extraAnnotations := parseLabelArg(plugin.SensuExtraAnnotation)
+		// log.Println(extraAnnotations)
+		annotations = mergeStringMaps(annotations, extraAnnotations)
+	}
+	// alert annotations (including rewritten ones) and extra annotations can use prometheus template variables
+	annotations = renderTemplateValues(annotations, a)
+	log.Printf("Sending Alert %s to %s", sensuAlertName, proxyEntityName)
+	err := sendAlertsToSensu(alertName, sensuAlertName, proxyEntityName, output, labels, annotations, sensuStatus)
+	eventsPostedTotal.WithLabelValues(resultLabel(err)).Inc()
+	if err != nil {
+		log.Printf("Error sending Alert %s to %s", sensuAlertName, proxyEntityName)
+		return err
 	}
-	return count
+	return nil
 }
 
 func processSensuEventsToClose(events []*v2.Event, alerts []models.GettableAlert) int {
-	count := 0
-	results := make(chan int, len(events))
+	errs := runPool(len(events), func(i int) error {
+		e := events[i]
+		v, ok := e.Check.Labels["fingerprint"]
+		if !ok || checkFingerprint(alerts, v) {
+			return nil
+		}
+		if reason := grace.wait(e, v); reason != "" {
+			log.Printf("Not closing %s yet: %s\n", e.Check.Name, reason)
+			report.add(DryRunEntry{Action: actionSkip, Reason: reason, Alert: e.Check.Labels["alertname"], Fingerprint: v})
+			return nil
+		}
+		log.Printf("Closing %s \n", e.Check.Name)
+		output := fmt.Sprintf("Resolved Automatically \n %s", e.Check.Output)
+		// close it in the same entity, an empty proxy entity would be replaced by --sensu-agent-entity
+		event := newSensuEvent(e.Check.Labels["alertname"], e.Check.Name, eventEntityName(e), output, e.Check.Labels, e.Check.Annotations, 0)
+		// close it in the same namespace, events can come from --auto-close-namespaces
+		if e.Check.Namespace != "" {
+			event.Check.Namespace = e.Check.Namespace
+		}
+		if plugin.DryRun {
+			report.add(DryRunEntry{Action: actionClose, Reason: "alert not found in alert manager", Alert: e.Check.Labels["alertname"], Fingerprint: v, Event: event})
+			return nil
+		}
+		err := submitEvent(event)
+		eventsClosedTotal.WithLabelValues(resultLabel(err)).Inc()
+		if err != nil {
+			log.Printf("Error closing %s \n", e.Check.Name)
+		}
+		return err
+	})
+	return errorCount(errs)
+}
+
+// get alerts from all AM instances in parallel and merge them by fingerprint
+// it only returns an error if all instances are unreachable
+func getAlertManagerEvents() ([]models.GettableAlert, []string, error) {
+	var unreachable []string
+	if len(plugin.Sources) == 0 {
+		return []models.GettableAlert{}, unreachable, fmt.Errorf("Failed to get alert manager alerts: no alert manager api url configured")
+	}
+	alertsList := make([][]models.GettableAlert, len(plugin.Sources))
+	errorsList := make([]error, len(plugin.Sources))
 	var wg sync.WaitGroup
-	for _, e := range events {
+	for i, source := range plugin.Sources {
 		wg.Add(1)
-		go func(e *v2.Event) {
+		go func(i int, source AlertmanagerSource) {
 			defer wg.Done()
-			for k, v := range e.Check.Labels {
-				if k == "fingerprint" {
-					if !checkFingerprint(alerts, v) {
-						log.Printf("Closing %s \n", e.Check.Name)
-						output := fmt.Sprintf("Resolved Automatically \n %s", e.Check.Output)
-						err := sendAlertsToSensu(e.Check.Labels["alertname"], e.Check.Name, e.Check.ProxyEntityName, output, e.Check.Labels, e.Check.Annotations, 0)
-						if err != nil {
-							log.Printf("Error closing %s \n", e.Check.Name)
-							results <- 1
-							// count++
-						}
-					}
-				}
-			}
-		}(e)
+			alertsList[i], errorsList[i] = getAlertManagerAlerts(source)
+		}(i, source)
 	}
 	wg.Wait()
-	close(results)
-	for r := range results {
-		count += r
+	var lastErr error
+	for i, err := range errorsList {
+		if err != nil {
+			log.Printf("[ERROR] %s", err)
+			unreachable = append(unreachable, plugin.Sources[i].URL)
+			lastErr = err
+		}
 	}
-	return count
+	if len(unreachable) == len(plugin.Sources) {
+		return []models.GettableAlert{}, unreachable, lastErr
+	}
+
+	result := filterAlerts(mergeAlerts(alertsList))
+
+	return result, unreachable, nil
 }
 
-// get alerts from AM
-func getAlertManagerEvents() ([]models.GettableAlert, error) {
-	body, err := getAlerts()
+// get alerts from one AM instance
+func getAlertManagerAlerts(source AlertmanagerSource) ([]models.GettableAlert, error) {
 	alerts := []models.GettableAlert{}
+	var apiURL string
+	var body []byte
+	var err error
+	if isOfflineSource(source.URL) {
+		apiURL = source.URL
+		body, err = readOfflineAlerts(source.URL)
+	} else {
+		apiURL, err = alertsQueryURL(source)
+		if err != nil {
+			return alerts, fmt.Errorf("Failed to parse alert manager url %s: %v", source.URL, err)
+		}
+		body, err = getAlerts(apiURL)
+	}
+	if err != nil {
+		return alerts, fmt.Errorf("Failed to get alert manager alerts from %s: %w", source.URL, err)
+	}
+
+	err = json.Unmarshal(body, &alerts)
 	if err != nil {
-		return alerts, fmt.Errorf("Failed to get alert manager alerts: %v", err)
+		return alerts, fmt.Errorf("Failed to get alert manager alerts from %s: %w", source.URL, &AlertmanagerResponseError{URL: apiURL, StatusCode: http.StatusOK, Body: body, Err: err})
+	}
+	// fingerprint and status are used without checks, edited dumps (--alert-manager-api-url file://) can miss them
+	// each alert is validated because models.GettableAlerts.Validate skips empty alerts
+	for i := range alerts {
+		err = alerts[i].Validate(strfmt.Default)
+		if err != nil {
+			return alerts, fmt.Errorf("Failed to get alert manager alerts from %s: %w", source.URL, &AlertmanagerResponseError{URL: apiURL, StatusCode: http.StatusOK, Body: body, Err: fmt.Errorf("alert %d: %v", i, err)})
+		}
 	}
 
-	_ = json.Unmarshal(body, &alerts)
+	alertsFetchedTotal.Add(float64(len(alerts)))
 
-	result := filterAlerts(alerts)
+	return relabelAlerts(addSourceLabels(alerts, source)), nil
+}
 
-	return result, nil
+// add query parameters to alert manager api url to filter alerts in alert manager side
+// matchers using static labels from source cannot be sent, they are only used by filterAlerts
+// no matcher is sent with --relabel-configs, because selectors can use labels created by relabel rules
+func alertsQueryURL(source AlertmanagerSource) (string, error) {
+	u, err := url.Parse(source.URL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("active", strconv.FormatBool(plugin.AlertmanagerActive))
+	query.Set("silenced", strconv.FormatBool(plugin.AlertmanagerSilenced))
+	query.Set("inhibited", strconv.FormatBool(plugin.AlertmanagerInhibited))
+	query.Set("unprocessed", strconv.FormatBool(plugin.AlertmanagerUnprocessed))
+	if plugin.AlertmanagerReceiver != "" {
+		query.Set("receiver", plugin.AlertmanagerReceiver)
+	}
+	// relabel rules change labels after alerts are fetched, then matchers are only used by filterAlerts
+	if len(plugin.RelabelConfigs) != 0 {
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+	for _, matcher := range plugin.LabelSelector {
+		if sourceStaticLabel(source, matcher.Name) {
+			continue
+		}
+		query.Add("filter", matcher.String())
+	}
+	// alert manager uses AND between filters, then exclude labels are sent negated
+	for _, matcher := range plugin.ExcludeLabels {
+		if sourceStaticLabel(source, matcher.Name) {
+			continue
+		}
+		negated, err := negateMatcher(matcher)
+		if err != nil {
+			return "", err
+		}
+		query.Add("filter", negated.String())
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// check if label name is added by source
+func sourceStaticLabel(source AlertmanagerSource, name string) bool {
+	if source.Name == "" {
+		return false
+	}
+	if name == sourceLabel {
+		return true
+	}
+	_, found := source.Labels[name]
+	return found
+}
+
+// return the opposite matcher. e.g. alertname!="TargetDown" for alertname="TargetDown"
+func negateMatcher(matcher *labels.Matcher) (*labels.Matcher, error) {
+	var matchType labels.MatchType
+	switch matcher.Type {
+	case labels.MatchEqual:
+		matchType = labels.MatchNotEqual
+	case labels.MatchNotEqual:
+		matchType = labels.MatchEqual
+	case labels.MatchRegexp:
+		matchType = labels.MatchNotRegexp
+	case labels.MatchNotRegexp:
+		matchType = labels.MatchRegexp
+	}
+	return labels.NewMatcher(matchType, matcher.Name, matcher.Value)
+}
+
+// parse alert manager instances from --alert-manager-sources or --alert-manager-api-url
+func parseAlertmanagerSources() ([]AlertmanagerSource, error) {
+	sources := []AlertmanagerSource{}
+	if plugin.AlertmanagerSources != "" {
+		err := json.Unmarshal([]byte(plugin.AlertmanagerSources), &sources)
+		if err != nil {
+			return sources, fmt.Errorf("Please use Format: [{\"name\":\"prod-eu\",\"url\":\"http://alertmanager:9093/api/v2/alerts\",\"labels\":{\"cluster\":\"prod-eu\"}}]. Wrong format --alert-manager-sources: %v", err)
+		}
+		for _, source := range sources {
+			if source.Name == "" {
+				return sources, fmt.Errorf("Please add a name for each source in --alert-manager-sources")
+			}
+			if !checkURL(source.URL) && !isOfflineSource(source.URL) {
+				return sources, fmt.Errorf("Please use a valid URL, file:// path or - for stdin. Wrong url %s in --alert-manager-sources %s", source.URL, source.Name)
+			}
+		}
+		return sources, nil
+	}
+	for _, u := range splitList(plugin.AlertmanagerAPIURL) {
+		if !checkURL(u) && !isOfflineSource(u) {
+			return sources, fmt.Errorf("Please use a valid URL, file:// path or - for stdin. Wrong format --alert-manager-api-url %s", u)
+		}
+		sources = append(sources, AlertmanagerSource{URL: u})
+	}
+	return sources, nil
+}
+
+// add source name and static labels in each alert and update its fingerprint
+// labels from alert manager have priority over static labels
+func addSourceLabels(alerts []models.GettableAlert, source AlertmanagerSource) []models.GettableAlert {
+	if source.Name == "" {
+		return alerts
+	}
+	for i, alert := range alerts {
+		labels := models.LabelSet{}
+		for k, v := range alert.Labels {
+			labels[k] = v
+		}
+		for k, v := range source.Labels {
+			if labels[k] == "" {
+				labels[k] = v
+			}
+		}
+		labels[sourceLabel] = source.Name
+		alerts[i].Labels = labels
+		fingerprint := labelsFingerprint(labels)
+		alerts[i].Fingerprint = &fingerprint
+	}
+	return alerts
+}
+
+// same fingerprint algorithm used by alert manager
+func labelsFingerprint(labels models.LabelSet) string {
+	labelSet := model.LabelSet{}
+	for k, v := range labels {
+		labelSet[model.LabelName(k)] = model.LabelValue(v)
+	}
+	return labelSet.Fingerprint().String()
+}
+
+// merge alerts from many AM instances using fingerprint and keeping the most recent updated one
+func mergeAlerts(alertsList [][]models.GettableAlert) []models.GettableAlert {
+	result := []models.GettableAlert{}
+	position := make(map[string]int)
+	for _, alerts := range alertsList {
+		for _, alert := range alerts {
+			if alert.Fingerprint == nil {
+				result = append(result, alert)
+				continue
+			}
+			i, found := position[*alert.Fingerprint]
+			if !found {
+				position[*alert.Fingerprint] = len(result)
+				result = append(result, alert)
+				continue
+			}
+			if alertUpdatedAt(alert).After(alertUpdatedAt(result[i])) {
+				result[i] = alert
+			}
+		}
+	}
+	return result
+}
+
+func alertUpdatedAt(alert models.GettableAlert) time.Time {
+	if alert.UpdatedAt == nil {
+		return time.Time{}
+	}
+	return time.Time(*alert.UpdatedAt)
 }
 
 // send alerts to Sensu Agent API
 func sendAlertsToSensu(alertName, sensuAlertName, proxyEntity, output string, labels, annotations map[string]string, sensuStatus uint32) error {
+	payload := newSensuEvent(alertName, sensuAlertName, proxyEntity, output, labels, annotations, sensuStatus)
+	if plugin.DryRun {
+		report.add(DryRunEntry{Action: actionCreate, Alert: labels["alertname"], Fingerprint: labels["fingerprint"], Event: payload})
+		return nil
+	}
+	err := submitEvent(payload)
+	if err != nil {
+		return fmt.Errorf("[ERROR] postOrGet %s", err)
+	}
+	return nil
+
+}
+
+// create sensu event from alert details
+func newSensuEvent(alertName, sensuAlertName, proxyEntity, output string, labels, annotations map[string]string, sensuStatus uint32) *v2.Event {
 	var SensuHandlers []string
 	if strings.Contains(plugin.SensuHandler, ",") {
 		SensuHandlers = strings.Split(plugin.SensuHandler, ",")
 	}
 	agentEntity := fmt.Sprintf("entity:%s", plugin.SensuAgentEntity)
-	payload := &v2.Event{
+	return &v2.Event{
 		Check: &v2.Check{
 			Output:          output,
 			Command:         removeSpecialCharacters(alertName),
@@ -573,22 +1466,36 @@ func sendAlertsToSensu(alertName, sensuAlertName, proxyEntity, output string, la
 			},
 		},
 	}
-	err := submitEventAgentAPI(payload)
-	if err != nil {
-		return fmt.Errorf("[ERROR] postOrGet %s", err)
-	}
-	return nil
-
 }
 
-// Print check output
+// Print check output using --output-template or --output-format
 func printAlert(alert models.GettableAlert, alertName string) (value string) {
+	if plugin.OutputTmpl != nil {
+		output, err := executeTemplate(plugin.OutputTmpl, alert)
+		if err == nil && output != "" {
+			return output
+		}
+		log.Printf("Cannot use output template for alert %s, using %s format: %v", alertName, plugin.OutputFormat, err)
+	}
+	switch plugin.OutputFormat {
+	case "markdown":
+		return printAlertMarkdown(alert, alertName)
+	case "json":
+		return printAlertJSON(alert, alertName)
+	case "summary":
+		return printAlertSummary(alert, alertName)
+	}
+	return printAlertPlain(alert, alertName)
+}
+
+// Print check output in plain text with sorted labels and annotations
+func printAlertPlain(alert models.GettableAlert, alertName string) (value string) {
 	var valueLabels, valueAnnotations, status string
-	for k, v := range alert.Labels {
-		valueLabels += fmt.Sprintf(" - %s: %s \n", k, v)
+	for _, k := range sortedKeys(alert.Labels) {
+		valueLabels += fmt.Sprintf(" - %s: %s \n", k, alert.Labels[k])
 	}
-	for k, v := range alert.Annotations {
-		valueAnnotations += fmt.Sprintf(" - %s: %s \n", k, v)
+	for _, k := range sortedKeys(alert.Annotations) {
+		valueAnnotations += fmt.Sprintf(" - %s: %s \n", k, alert.Annotations[k])
 	}
 	status = *alert.Status.State
 	value = "Labels: \n"
@@ -605,6 +1512,67 @@ func printAlert(alert models.GettableAlert, alertName string) (value string) {
 	return value
 }
 
+// Print check output in markdown
+func printAlertMarkdown(alert models.GettableAlert, alertName string) (value string) {
+	value = fmt.Sprintf("**%s** (%s)\n\n", alertName, *alert.Status.State)
+	value += "**Labels**\n"
+	for _, k := range sortedKeys(alert.Labels) {
+		value += fmt.Sprintf("- `%s`: %s\n", k, alert.Labels[k])
+	}
+	value += "\n**Annotations**\n"
+	for _, k := range sortedKeys(alert.Annotations) {
+		value += fmt.Sprintf("- `%s`: %s\n", k, alert.Annotations[k])
+	}
+	value += "\n"
+	if plugin.AlertmanagerExternalURL != "" {
+		value += fmt.Sprintf("[Alert Manager](%s) ", strings.TrimSpace(printAlertManagerURL(alertName)))
+	}
+	if alert.GeneratorURL != "" {
+		value += fmt.Sprintf("[Prometheus](%s)", alert.GeneratorURL)
+	}
+	return strings.TrimSpace(value)
+}
+
+// Print check output in json. Maps are encoded with sorted keys
+func printAlertJSON(alert models.GettableAlert, alertName string) string {
+	output := struct {
+		Alertname       string            `json:"alertname"`
+		Status          string            `json:"status"`
+		Labels          map[string]string `json:"labels"`
+		Annotations     map[string]string `json:"annotations"`
+		AlertmanagerURL string            `json:"alertmanager_url,omitempty"`
+		GeneratorURL    string            `json:"generator_url,omitempty"`
+	}{
+		Alertname:    alertName,
+		Status:       *alert.Status.State,
+		Labels:       alert.Labels,
+		Annotations:  alert.Annotations,
+		GeneratorURL: string(alert.GeneratorURL),
+	}
+	if plugin.AlertmanagerExternalURL != "" {
+		output.AlertmanagerURL = strings.TrimSpace(printAlertManagerURL(alertName))
+	}
+	encoded, err := json.Marshal(output)
+	if err != nil {
+		return printAlertPlain(alert, alertName)
+	}
+	return string(encoded)
+}
+
+// Print only summary and description annotations
+func printAlertSummary(alert models.GettableAlert, alertName string) string {
+	var lines []string
+	for _, k := range []string{"summary", "description"} {
+		if alert.Annotations[k] != "" {
+			lines = append(lines, alert.Annotations[k])
+		}
+	}
+	if len(lines) == 0 {
+		return fmt.Sprintf("%s is %s", alertName, *alert.Status.State)
+	}
+	return strings.Join(lines, "\n")
+}
+
 func printAlertManagerURL(alertName string) string {
 	sourceURL := url.QueryEscape(fmt.Sprintf("{alertname=\"%s\"}", alertName))
 	return fmt.Sprintf("%s/#/alerts?silenced=false&inhibited=false&active=true&filter=%s \n", plugin.AlertmanagerExternalURL, sourceURL)
@@ -632,18 +1600,14 @@ func alertDetails(alert models.GettableAlert) (alertName, sensuAlertName, cluste
 		if k == "node" {
 			withNamespace = false
 		}
-		key := k
-		// if plugin.RewriteAnnotation != "" {
-		// 	rule := makeRewriteAnnotation(plugin.RewriteAnnotation)
-		// 	tmp, err := rewriteAnnotation(key, rule)
-		// 	if err == nil && tmp != "" {
-		// 		key = tmp
-		// 	}
-		// }
-		labels[key] = v
+		// labels can be changed before using --relabel-configs
+		labels[k] = v
 	}
 	// extra label
 	labels[plugin.Name] = "owner"
+	if plugin.InstanceID != "" {
+		labels[instanceLabel()] = plugin.InstanceID
+	}
 	for k, v := range alert.Annotations {
 		key := k
 		if plugin.RewriteAnnotation != "" {
@@ -718,33 +1682,93 @@ func alertDetails(alert models.GettableAlert) (alertName, sensuAlertName, cluste
 			sensuAlertName = fmt.Sprintf("%s-%s", alertName, labels["node"])
 		}
 	}
+	// alerts from named sources must be unique per source
+	if labels[sourceLabel] != "" {
+		sensuAlertName = fmt.Sprintf("%s-%s", sensuAlertName, labels[sourceLabel])
+	}
 	return alertName, sensuAlertName, cluster, kubernetesResource, labels, annotations
 }
 
 // get http alerts from AM
-func getAlerts() (result []byte, err error) {
+func getAlerts(apiURL string) (result []byte, err error) {
 	client := &http.Client{
 		Timeout: time.Second * 10,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &alertmanagerTLSConfig,
+		},
 	}
-	req, err := http.NewRequest(http.MethodGet, plugin.AlertmanagerAPIURL, nil)
+	req, err := http.NewRequest(http.MethodGet, apiURL, nil)
 	if err != nil {
 		log.Printf("[ERROR]  GET %s", err)
 		return nil, err
 	}
+	err = setAlertmanagerAuth(req)
+	if err != nil {
+		log.Printf("[ERROR] auth %s", err)
+		return nil, err
+	}
+	start := time.Now()
 	resp, err := client.Do(req)
+	observeRequest(targetAlertmanager, start)
 	if err != nil {
 		log.Printf("[ERROR] client %s", err)
 		return nil, err
 	}
+	defer resp.Body.Close()
 	result, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("[ERROR] ReadAll %s", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, &AlertmanagerResponseError{URL: apiURL, StatusCode: resp.StatusCode, Body: result}
+	}
 	return result, nil
 }
 
+// parse severity status mapping. e.g. critical=2,warning=1,info=0,none=skip
+func parseSeverityStatus(s string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		severity, status := splitString(pair, "=")
+		if severity == "" || status == "" {
+			return mapping, fmt.Errorf("invalid pair %q", pair)
+		}
+		if !validSensuStatus(status) {
+			return mapping, fmt.Errorf("invalid status %q for severity %q", status, severity)
+		}
+		mapping[strings.ToLower(strings.TrimSpace(severity))] = strings.TrimSpace(status)
+	}
+	return mapping, nil
+}
+
+// check if value can be used as sensu check status
+func validSensuStatus(s string) bool {
+	return stringInSlice(strings.TrimSpace(s), []string{"0", "1", "2", "3", severitySkip})
+}
+
+// find sensu check status using alert severity label. Returns false if alert should not be sent
+func alertSensuStatus(alert models.GettableAlert) (uint32, bool) {
+	status := strings.TrimSpace(plugin.AlertmanagerSeverityDefault)
+	if severity, ok := alert.Labels[plugin.AlertmanagerSeverityLabel]; ok {
+		if value, found := plugin.SeverityStatus[strings.ToLower(severity)]; found {
+			status = value
+		}
+	}
+	if status == severitySkip {
+		return 0, false
+	}
+	sensuStatus, err := strconv.ParseUint(status, 10, 32)
+	if err != nil {
+		return sensu.CheckStateCritical, true
+	}
+	return uint32(sensuStatus), true
+}
+
 // check if fingerprint matches
 func checkFingerprint(alerts []models.GettableAlert, f string) bool {
 	for _, a := range alerts {
@@ -759,10 +1783,18 @@ func checkFingerprint(alerts []models.GettableAlert, f string) bool {
 func submitEventAgentAPI(event *v2.Event) error {
 
 	encoded, _ := json.Marshal(event)
-	resp, err := http.Post(plugin.AgentAPIURL, "application/json", bytes.NewBuffer(encoded))
+	resp, err := doWithRetry(http.DefaultClient, targetAgent, func() (*http.Request, error) {
+		req, err := http.NewRequest("POST", plugin.AgentAPIURL, bytes.NewBuffer(encoded))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
 		return fmt.Errorf("Failed to post event to %s failed: %v", plugin.AgentAPIURL, err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
 		return fmt.Errorf("POST of event to %s failed with status %v\nevent: %s", plugin.AgentAPIURL, resp.Status, string(encoded))
 	}
@@ -791,8 +1823,11 @@ func authenticate() (Auth, error) {
 
 	req.SetBasicAuth(plugin.APIBackendUser, plugin.APIBackendPass)
 
+	start := time.Now()
 	resp, err := client.Do(req)
+	observeRequest(targetBackend, start)
 	if err != nil {
+		backendAuthFailuresTotal.Inc()
 		return auth, fmt.Errorf("error executing auth request: %v", err)
 	}
 
@@ -803,6 +1838,7 @@ func authenticate() (Auth, error) {
 	}
 
 	if strings.HasPrefix(string(body), "Unauthorized") {
+		backendAuthFailuresTotal.Inc()
 		return auth, fmt.Errorf("authorization failed for user %s", plugin.APIBackendUser)
 	}
 
@@ -816,56 +1852,121 @@ func authenticate() (Auth, error) {
 	return auth, err
 }
 
-// get events from sensu-backend-api
-func getEvents(auth Auth, namespace string) ([]*types.Event, error) {
+// get events from sensu-backend-api page by page using --api-backend-page-size
+// only events created by this plugin and not resolved are requested and each page is sent to fn
+// an empty namespace gets events from all namespaces
+func getEvents(namespace string, fn func(events []*types.Event) error) error {
 	client := http.DefaultClient
 	client.Transport = http.DefaultTransport
 
-	url := fmt.Sprintf("%s://%s:%d/api/core/v2/namespaces/%s/events", plugin.Protocol, plugin.APIBackendHost, plugin.APIBackendPort, namespace)
-	events := []*types.Event{}
+	eventsURL := fmt.Sprintf("%s://%s:%d/api/core/v2/namespaces/%s/events", plugin.Protocol, plugin.APIBackendHost, plugin.APIBackendPort, namespace)
+	if namespace == "" {
+		eventsURL = fmt.Sprintf("%s://%s:%d/api/core/v2/events", plugin.Protocol, plugin.APIBackendHost, plugin.APIBackendPort)
+	}
 
 	if plugin.Secure {
 		client.Transport.(*http.Transport).TLSClientConfig = &tlsConfig
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return events, fmt.Errorf("error creating GET request for %s: %v", url, err)
-	}
-
-	if len(plugin.APIBackendKey) == 0 {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", auth.AccessToken))
-	} else {
-		req.Header.Set("Authorization", fmt.Sprintf("Key %s", plugin.APIBackendKey))
+	continueToken := ""
+	for {
+		query := url.Values{}
+		query.Set("labelSelector", fmt.Sprintf("%s == owner", plugin.Name))
+		query.Set("fieldSelector", "event.check.status != 0")
+		if plugin.APIBackendPageSize > 0 {
+			query.Set("limit", strconv.Itoa(plugin.APIBackendPageSize))
+		}
+		if continueToken != "" {
+			query.Set("continue", continueToken)
+		}
+		events, next, err := getEventsPage(client, eventsURL+"?"+query.Encode())
+		if err != nil {
+			return err
+		}
+		err = fn(filterEvents(events))
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		continueToken = next
 	}
-	req.Header.Set("Content-Type", "application/json")
+}
 
-	resp, err := client.Do(req)
+// get one page of events from sensu-backend-api. Sensu-Continue header has the token for the next page
+func getEventsPage(client *http.Client, pageURL string) ([]*types.Event, string, error) {
+	events := []*types.Event{}
+	resp, err := doWithBackendAuth(func(auth Auth) (*http.Response, error) {
+		return doWithRetry(client, targetBackend, func() (*http.Request, error) {
+			req, err := http.NewRequest("GET", pageURL, nil)
+			if err != nil {
+				return nil, fmt.Errorf("error creating GET request for %s: %v", pageURL, err)
+			}
+			setBackendAuthHeader(req, auth)
+			req.Header.Set("Content-Type", "application/json")
+			return req, nil
+		})
+	})
 	if err != nil {
-		return events, fmt.Errorf("error executing GET request for %s: %v", url, err)
+		return events, "", fmt.Errorf("error executing GET request for %s: %v", pageURL, err)
 	}
 
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return events, fmt.Errorf("error reading response body during getEvents: %v", err)
+		return events, "", fmt.Errorf("error reading response body during getEvents: %v", err)
+	}
+	trim := 64
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return events, "", fmt.Errorf("GET %s failed with status %v\nFirst %d bytes of response: %s", pageURL, resp.Status, trim, trimBody(body, trim))
 	}
 
 	err = json.Unmarshal(body, &events)
 	if err != nil {
-		trim := 64
-		return events, fmt.Errorf("error unmarshalling response during getEvents: %v\nFirst %d bytes of response: %s", err, trim, trimBody(body, trim))
+		return events, "", fmt.Errorf("error unmarshalling response during getEvents: %v\nFirst %d bytes of response: %s", err, trim, trimBody(body, trim))
+	}
+	return events, resp.Header.Get("Sensu-Continue"), nil
+}
+
+// label with --instance-id added in all events. e.g. sensu-alertmanager-events-instance
+func instanceLabel() string {
+	return plugin.Name + "-instance"
+}
+
+// check if event was created by this instance (--instance-id)
+// events without instance label were created before --instance-id and belong to any instance
+// events from other instances are ignored, also when --instance-id is not used
+func ownedByInstance(event *types.Event) bool {
+	instance, ok := event.Check.ObjectMeta.Labels[instanceLabel()]
+	return !ok || instance == plugin.InstanceID
+}
+
+// entity name of an existing event: its proxy entity or, for events created without one, the entity that ran the check
+func eventEntityName(event *types.Event) string {
+	if event.Check.ProxyEntityName == "" && event.Entity != nil {
+		return event.Entity.Name
 	}
-	result := filterEvents(events)
+	return event.Check.ProxyEntityName
+}
 
-	return result, err
+// namespaces used in auto close from --auto-close-namespaces. An empty namespace means all namespaces
+func autoCloseNamespaces() []string {
+	if strings.TrimSpace(plugin.AutoCloseNamespaces) == "*" {
+		return []string{""}
+	}
+	namespaces := splitList(plugin.AutoCloseNamespaces)
+	if len(namespaces) == 0 {
+		return []string{plugin.SensuNamespace}
+	}
+	return namespaces
 }
 
 // filter events from sensu-backend-api to look only events created by this plugin
 func filterEvents(events []*types.Event) []*types.Event {
 	var result, partialResult []*types.Event
 	for _, event := range events {
-		if event.Check.ObjectMeta.Labels[plugin.Name] == "owner" && event.Check.Status != 0 {
+		if event.Check.ObjectMeta.Labels[plugin.Name] == "owner" && event.Check.Status != 0 && ownedByInstance(event) {
 			result = append(result, event)
 		}
 	}
@@ -904,23 +2005,37 @@ func parseLabelArg(labelArg string) map[string]string {
 	return labels
 }
 
-// filter alerts using map[string]string from plugin.LabelSelector
+// parse alert manager matchers like {severity=~"critical|warning",namespace!~"kube-.*"}
+func parseMatchers(s string) ([]*labels.Matcher, error) {
+	matchers, err := labels.ParseMatchers(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("no matchers found")
+	}
+	return matchers, nil
+}
+
+// filter alerts using matchers from plugin.LabelSelector and plugin.ExcludeLabels
 func filterAlerts(alerts []models.GettableAlert) (result []models.GettableAlert) {
 
 	for _, alert := range alerts {
 		selected := true
-		// check if it find labels selector
-		for key, value := range plugin.LabelSelector {
-			if alert.Labels[key] != value {
+		// check if it matches all labels selector
+		for _, matcher := range plugin.LabelSelector {
+			if !matcher.Matches(alert.Labels[matcher.Name]) {
 				selected = false
+				skipAlert(alert, filterReasonSelector)
 				break
 			}
 		}
 		// exclude alerts based on labels
-		// if found, remove from alert manager list
-		for key, value := range plugin.ExcludeLabels {
-			if alert.Labels[key] == value {
+		// if any matches, remove from alert manager list
+		for _, matcher := range plugin.ExcludeLabels {
+			if selected && matcher.Matches(alert.Labels[matcher.Name]) {
 				selected = false
+				skipAlert(alert, filterReasonExcludeLabel)
 				break
 			}
 		}
@@ -933,6 +2048,54 @@ func filterAlerts(alerts []models.GettableAlert) (result []models.GettableAlert)
 	return result
 }
 
+// split alert manager exclude alert list flag
+func excludeAlertList() []string {
+	var AlertmanagerExcludeAlertList []string
+	if strings.Contains(plugin.AlertmanagerExcludeAlerts, ",") {
+		AlertmanagerExcludeAlertList = strings.Split(plugin.AlertmanagerExcludeAlerts, ",")
+	}
+	return AlertmanagerExcludeAlertList
+}
+
+// sorted keys to create deterministic outputs
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// split comma separated list removing empty values
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// write file using a temporary file (created with 0600 permissions) renamed to path
+func writeFileAtomic(path string, body []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
 // Use to exclude some alerts from alert manager before sending it to sensu agent api
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
@@ -6,8 +6,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/alertmanager/pkg/labels"
 	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	v2 "github.com/sensu/sensu-go/api/core/v2"
 	"github.com/stretchr/testify/assert"
@@ -186,3 +191,312 @@ func TestCheckURL(t *testing.T) {
 	test6 := checkURL("http://")
 	assert.False(t, test6)
 }
+
+func TestParseSeverityStatus(t *testing.T) {
+	res1, err1 := parseSeverityStatus("critical=2,Warning=1,info=0,none=skip")
+	assert.NoError(t, err1)
+	assert.Equal(t, map[string]string{"critical": "2", "warning": "1", "info": "0", "none": "skip"}, res1)
+	_, err2 := parseSeverityStatus("critical=5")
+	assert.Error(t, err2)
+	_, err3 := parseSeverityStatus("critical")
+	assert.Error(t, err3)
+	res4, err4 := parseSeverityStatus("")
+	assert.NoError(t, err4)
+	assert.Empty(t, res4)
+}
+
+func TestAlertSensuStatus(t *testing.T) {
+	plugin.AlertmanagerSeverityLabel = "severity"
+	plugin.AlertmanagerSeverityDefault = "2"
+	plugin.SeverityStatus, _ = parseSeverityStatus("critical=2,warning=1,info=0,none=skip")
+	alert := models.GettableAlert{Alert: models.Alert{Labels: models.LabelSet{"alertname": "TargetDown", "severity": "warning"}}}
+	status, send := alertSensuStatus(alert)
+	assert.True(t, send)
+	assert.Equal(t, uint32(1), status)
+	alert.Labels["severity"] = "none"
+	_, send = alertSensuStatus(alert)
+	assert.False(t, send)
+	alert.Labels["severity"] = "unknown"
+	status, send = alertSensuStatus(alert)
+	assert.True(t, send)
+	assert.Equal(t, uint32(2), status)
+	plugin.AlertmanagerSeverityDefault = "skip"
+	_, send = alertSensuStatus(alert)
+	assert.False(t, send)
+	plugin.AlertmanagerSeverityDefault = ""
+	plugin.SeverityStatus = nil
+}
+
+func testAlert(fingerprint, alertname string, updatedAt time.Time) models.GettableAlert {
+	state := "active"
+	receiver := "default"
+	updated := strfmt.DateTime(updatedAt)
+	starts := strfmt.DateTime(updatedAt.Add(-time.Hour))
+	ends := strfmt.DateTime(updatedAt.Add(time.Hour))
+	return models.GettableAlert{
+		Alert:       models.Alert{Labels: models.LabelSet{"alertname": alertname}},
+		Annotations: models.LabelSet{},
+		Fingerprint: &fingerprint,
+		StartsAt:    &starts,
+		EndsAt:      &ends,
+		UpdatedAt:   &updated,
+		Receivers:   []*models.Receiver{{Name: &receiver}},
+		Status:      &models.AlertStatus{State: &state, InhibitedBy: []string{}, SilencedBy: []string{}},
+	}
+}
+
+func TestMergeAlerts(t *testing.T) {
+	now := time.Now()
+	list1 := []models.GettableAlert{testAlert("a", "TargetDown", now), testAlert("b", "NodeDown", now)}
+	list2 := []models.GettableAlert{testAlert("b", "NodeDownNewer", now.Add(time.Minute)), testAlert("c", "PodDown", now)}
+	res := mergeAlerts([][]models.GettableAlert{list1, list2})
+	assert.Len(t, res, 3)
+	assert.Equal(t, "a", *res[0].Fingerprint)
+	assert.Equal(t, "NodeDownNewer", res[1].Labels["alertname"])
+	assert.Equal(t, "c", *res[2].Fingerprint)
+	res2 := mergeAlerts([][]models.GettableAlert{list2, list1})
+	assert.Len(t, res2, 3)
+	assert.Equal(t, "NodeDownNewer", res2[0].Labels["alertname"])
+}
+
+func TestGetAlertManagerEvents(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	am1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := json.Marshal([]models.GettableAlert{testAlert("a", "TargetDown", now), testAlert("b", "NodeDown", now)})
+		_, _ = w.Write(body)
+	}))
+	defer am1.Close()
+	am2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := json.Marshal([]models.GettableAlert{testAlert("b", "NodeDown", now), testAlert("c", "PodDown", now)})
+		_, _ = w.Write(body)
+	}))
+	defer am2.Close()
+	plugin.Sources = []AlertmanagerSource{{URL: am1.URL}, {URL: am2.URL}}
+	alerts, unreachable, err := getAlertManagerEvents()
+	assert.NoError(err)
+	assert.Empty(unreachable)
+	assert.Len(alerts, 3)
+
+	plugin.Sources = []AlertmanagerSource{{URL: am1.URL}, {URL: "http://127.0.0.1:1/api/v2/alerts"}}
+	alerts, unreachable, err = getAlertManagerEvents()
+	assert.NoError(err)
+	assert.Equal([]string{"http://127.0.0.1:1/api/v2/alerts"}, unreachable)
+	assert.Len(alerts, 2)
+
+	plugin.Sources = []AlertmanagerSource{{URL: "http://127.0.0.1:1/api/v2/alerts"}}
+	_, _, err = getAlertManagerEvents()
+	assert.Error(err)
+	plugin.Sources = nil
+}
+
+func TestSplitList(t *testing.T) {
+	assert.Equal(t, []string{"http://am1:9093", "http://am2:9093"}, splitList("http://am1:9093, http://am2:9093,"))
+	assert.Empty(t, splitList(""))
+}
+
+func TestParseAlertmanagerSources(t *testing.T) {
+	plugin.AlertmanagerAPIURL = "http://am1:9093/api/v2/alerts,http://am2:9093/api/v2/alerts"
+	res1, err1 := parseAlertmanagerSources()
+	assert.NoError(t, err1)
+	assert.Equal(t, []AlertmanagerSource{{URL: "http://am1:9093/api/v2/alerts"}, {URL: "http://am2:9093/api/v2/alerts"}}, res1)
+	plugin.AlertmanagerSources = `[{"name":"prod-eu","url":"http://am.prod-eu:9093/api/v2/alerts","labels":{"cluster":"prod-eu"}}]`
+	res2, err2 := parseAlertmanagerSources()
+	assert.NoError(t, err2)
+	assert.Equal(t, []AlertmanagerSource{{Name: "prod-eu", URL: "http://am.prod-eu:9093/api/v2/alerts", Labels: map[string]string{"cluster": "prod-eu"}}}, res2)
+	plugin.AlertmanagerSources = `[{"url":"http://am.prod-eu:9093/api/v2/alerts"}]`
+	_, err3 := parseAlertmanagerSources()
+	assert.Error(t, err3)
+	plugin.AlertmanagerSources = `[{"name":"prod-eu","url":"am.prod-eu"}]`
+	_, err4 := parseAlertmanagerSources()
+	assert.Error(t, err4)
+	plugin.AlertmanagerSources = ""
+	plugin.AlertmanagerAPIURL = "file:///tmp/alerts.json,-"
+	res5, err5 := parseAlertmanagerSources()
+	assert.NoError(t, err5)
+	assert.Equal(t, []AlertmanagerSource{{URL: "file:///tmp/alerts.json"}, {URL: "-"}}, res5)
+	plugin.AlertmanagerSources = ""
+	plugin.AlertmanagerAPIURL = ""
+}
+
+func TestAddSourceLabels(t *testing.T) {
+	now := time.Now()
+	source1 := AlertmanagerSource{Name: "prod-eu", Labels: map[string]string{"cluster": "prod-eu"}}
+	source2 := AlertmanagerSource{Name: "prod-us", Labels: map[string]string{"cluster": "prod-us"}}
+	res1 := addSourceLabels([]models.GettableAlert{testAlert("a", "TargetDown", now)}, source1)
+	res2 := addSourceLabels([]models.GettableAlert{testAlert("a", "TargetDown", now)}, source2)
+	assert.Equal(t, "prod-eu", res1[0].Labels["cluster"])
+	assert.Equal(t, "prod-eu", res1[0].Labels[sourceLabel])
+	assert.NotEqual(t, *res1[0].Fingerprint, *res2[0].Fingerprint)
+	assert.Len(t, mergeAlerts([][]models.GettableAlert{res1, res2}), 2)
+	_, sensuAlertName, _, _, _, _ := alertDetails(res1[0])
+	assert.Equal(t, "TargetDown-prod-eu", sensuAlertName)
+	alert := testAlert("a", "TargetDown", now)
+	alert.Labels["cluster"] = "k8s-dev"
+	res3 := addSourceLabels([]models.GettableAlert{alert}, source1)
+	assert.Equal(t, "k8s-dev", res3[0].Labels["cluster"])
+	res4 := addSourceLabels([]models.GettableAlert{testAlert("a", "TargetDown", now)}, AlertmanagerSource{URL: "http://am1"})
+	assert.Equal(t, "a", *res4[0].Fingerprint)
+}
+
+func TestParseMatchers(t *testing.T) {
+	res1, err1 := parseMatchers(`{severity=~"critical|warning",namespace!~"kube-.*"}`)
+	assert.NoError(t, err1)
+	assert.Len(t, res1, 2)
+	assert.Equal(t, "severity", res1[0].Name)
+	assert.Equal(t, labels.MatchRegexp, res1[0].Type)
+	assert.Equal(t, labels.MatchNotRegexp, res1[1].Type)
+	res2, err2 := parseMatchers(`alertname=TargetDown,description="a=b, c"`)
+	assert.NoError(t, err2)
+	assert.Len(t, res2, 2)
+	assert.Equal(t, "a=b, c", res2[1].Value)
+	_, err3 := parseMatchers(`severity=~"(critical"`)
+	assert.Error(t, err3)
+	_, err4 := parseMatchers(`OneLabelOneValue`)
+	assert.Error(t, err4)
+	_, err5 := parseMatchers(`{}`)
+	assert.Error(t, err5)
+}
+
+func TestFilterAlerts(t *testing.T) {
+	now := time.Now()
+	alert1 := testAlert("a", "TargetDown", now)
+	alert1.Labels["severity"] = "critical"
+	alert1.Labels["namespace"] = "default"
+	alert2 := testAlert("b", "KubePodCrashLooping", now)
+	alert2.Labels["severity"] = "warning"
+	alert2.Labels["namespace"] = "kube-system"
+	alert3 := testAlert("c", "NodeDown", now)
+	alert3.Labels["severity"] = "info"
+	alerts := []models.GettableAlert{alert1, alert2, alert3}
+	plugin.LabelSelector, _ = parseMatchers(`{severity=~"critical|warning",namespace!~"kube-.*"}`)
+	res1 := filterAlerts(alerts)
+	assert.Len(t, res1, 1)
+	assert.Equal(t, "a", *res1[0].Fingerprint)
+	plugin.LabelSelector = nil
+	plugin.ExcludeLabels, _ = parseMatchers(`alertname=TargetDown,severity=info`)
+	res2 := filterAlerts(alerts)
+	assert.Len(t, res2, 1)
+	assert.Equal(t, "b", *res2[0].Fingerprint)
+	plugin.ExcludeLabels = nil
+	assert.Len(t, filterAlerts(alerts), 3)
+}
+
+func TestCheckArgsMatchers(t *testing.T) {
+	event := v2.FixtureEvent("entity1", "check1")
+	plugin.AlertmanagerLabelEntity = ""
+	plugin.SensuProxyEntity = ""
+	plugin.AlertmanagerLabelSelectors = `{severity=~"(critical"}`
+	status, err := checkArgs(event)
+	assert.Error(t, err)
+	assert.Equal(t, sensu.CheckStateWarning, status)
+	plugin.AlertmanagerLabelSelectors = `{severity=~"critical|warning"}`
+	plugin.AlertmanagerExcludeLabels = `namespace=~"kube-.*"`
+	status, err = checkArgs(event)
+	assert.NoError(t, err)
+	assert.Equal(t, sensu.CheckStateOK, status)
+	assert.Len(t, plugin.LabelSelector, 1)
+	assert.Len(t, plugin.ExcludeLabels, 1)
+	plugin.AlertmanagerLabelSelectors = ""
+	plugin.AlertmanagerExcludeLabels = ""
+	plugin.LabelSelector = nil
+	plugin.ExcludeLabels = nil
+}
+
+func TestAlertsQueryURL(t *testing.T) {
+	plugin.AlertmanagerActive = true
+	plugin.AlertmanagerSilenced = false
+	plugin.AlertmanagerInhibited = false
+	plugin.AlertmanagerUnprocessed = true
+	plugin.AlertmanagerReceiver = "sensu|default"
+	plugin.LabelSelector, _ = parseMatchers(`{severity=~"critical|warning",cluster="prod-eu"}`)
+	plugin.ExcludeLabels, _ = parseMatchers(`alertname=Watchdog,namespace=~"kube-.*"`)
+	res1, err1 := alertsQueryURL(AlertmanagerSource{URL: "http://am1:9093/api/v2/alerts"})
+	assert.NoError(t, err1)
+	u, _ := url.Parse(res1)
+	query := u.Query()
+	assert.Equal(t, "true", query.Get("active"))
+	assert.Equal(t, "false", query.Get("silenced"))
+	assert.Equal(t, "false", query.Get("inhibited"))
+	assert.Equal(t, "true", query.Get("unprocessed"))
+	assert.Equal(t, "sensu|default", query.Get("receiver"))
+	assert.Equal(t, []string{`severity=~"critical|warning"`, `cluster="prod-eu"`, `alertname!="Watchdog"`, `namespace!~"kube-.*"`}, query["filter"])
+	source := AlertmanagerSource{Name: "prod-eu", URL: "http://am1:9093/api/v2/alerts", Labels: map[string]string{"cluster": "prod-eu"}}
+	res2, err2 := alertsQueryURL(source)
+	assert.NoError(t, err2)
+	u, _ = url.Parse(res2)
+	assert.Equal(t, []string{`severity=~"critical|warning"`, `alertname!="Watchdog"`, `namespace!~"kube-.*"`}, u.Query()["filter"])
+	plugin.LabelSelector = nil
+	plugin.ExcludeLabels = nil
+	plugin.AlertmanagerReceiver = ""
+}
+
+func TestExecuteCheckSkipAutoClose(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer agent.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("sensu backend must not be called: %s", r.URL.Path)
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer backend.Close()
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := json.Marshal([]models.GettableAlert{testAlert("a", "TargetDown", now)})
+		_, _ = w.Write(body)
+	}))
+	defer am.Close()
+	amDown := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer amDown.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(backendURL.Port())
+	plugin.AgentAPIURL = agent.URL
+	plugin.APIBackendHost = backendURL.Hostname()
+	plugin.APIBackendPort = port
+	plugin.APIBackendKey = "key"
+	plugin.Protocol = "http"
+	plugin.SensuAutoClose = true
+
+	plugin.Sources = []AlertmanagerSource{{URL: am.URL}, {URL: amDown.URL}}
+	status, err := executeCheck(nil)
+	assert.Error(err)
+	assert.Equal(sensu.CheckStateWarning, status)
+
+	plugin.Sources = []AlertmanagerSource{{URL: amDown.URL}}
+	status, err = executeCheck(nil)
+	assert.Error(err)
+	assert.Equal(sensu.CheckStateCritical, status)
+
+	plugin.Sources = nil
+	plugin.SensuAutoClose = false
+	plugin.APIBackendKey = ""
+}
+
+func TestFilterEventsInstance(t *testing.T) {
+	legacy := testSensuEvent("TargetDown", "a")
+	own := testSensuEvent("NodeDown", "b")
+	own.Check.Labels[instanceLabel()] = "eu"
+	other := testSensuEvent("PodDown", "c")
+	other.Check.Labels[instanceLabel()] = "us"
+	events := []*v2.Event{legacy, own, other}
+
+	plugin.InstanceID = "eu"
+	assert.Equal(t, []*v2.Event{legacy, own}, filterEvents(events))
+	plugin.InstanceID = ""
+	assert.Equal(t, []*v2.Event{legacy}, filterEvents(events))
+}
+
+func TestAlertDetailsInstance(t *testing.T) {
+	alert := testAlert("a", "TargetDown", time.Now())
+	_, _, _, _, labels, _ := alertDetails(alert)
+	assert.NotContains(t, labels, instanceLabel())
+	plugin.InstanceID = "eu"
+	_, _, _, _, labels, _ = alertDetails(alert)
+	assert.Equal(t, "eu", labels[instanceLabel()])
+	assert.Equal(t, "owner", labels[plugin.Name])
+	plugin.InstanceID = ""
+}
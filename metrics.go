@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metricsNamespace is the prefix used in all metrics exposed in /metrics
+const metricsNamespace = "sensu_alertmanager_events"
+
+// reasons used in alertsFilteredTotal
+const (
+	filterReasonExcludedAlertname = "excluded_alertname"
+	filterReasonSelector          = "selector"
+	filterReasonExcludeLabel      = "exclude_label"
+	filterReasonNotActive         = "not_active"
+	filterReasonSeverity          = "severity"
+	filterReasonRelabel           = "relabel"
+)
+
+// targets used in requestDuration
+const (
+	targetAlertmanager = "alertmanager"
+	targetAgent        = "agent"
+	targetBackend      = "backend"
+)
+
+var (
+	alertsFetchedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "alerts_fetched_total",
+		Help:      "Number of alerts received from Alert Manager.",
+	})
+	alertsFilteredTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "alerts_filtered_total",
+		Help:      "Number of alerts not sent to Sensu by reason.",
+	}, []string{"reason"})
+	eventsPostedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_posted_total",
+		Help:      "Number of events posted to Sensu by result.",
+	}, []string{"result"})
+	eventsClosedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_closed_total",
+		Help:      "Number of events closed automatically by result.",
+	}, []string{"result"})
+	backendAuthFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "backend_auth_failures_total",
+		Help:      "Number of failed authentications in Sensu Backend API.",
+	})
+	requestRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_retries_total",
+		Help:      "Number of retried HTTP requests by target (agent or backend).",
+	}, []string{"target"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests by target (alertmanager, agent or backend).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"target"})
+)
+
+// observe request duration since start
+func observeRequest(target string, start time.Time) {
+	requestDuration.WithLabelValues(target).Observe(time.Since(start).Seconds())
+}
+
+// result label value
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
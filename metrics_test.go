@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterAlertsMetrics(t *testing.T) {
+	now := time.Now()
+	alert1 := testAlert("a", "TargetDown", now)
+	alert1.Labels["severity"] = "critical"
+	alert2 := testAlert("b", "NodeDown", now)
+	alert2.Labels["severity"] = "info"
+	selector := testutil.ToFloat64(alertsFilteredTotal.WithLabelValues(filterReasonSelector))
+	exclude := testutil.ToFloat64(alertsFilteredTotal.WithLabelValues(filterReasonExcludeLabel))
+	plugin.LabelSelector, _ = parseMatchers(`severity=~"critical|warning"`)
+	plugin.ExcludeLabels, _ = parseMatchers(`alertname=TargetDown`)
+	res := filterAlerts([]models.GettableAlert{alert1, alert2})
+	assert.Empty(t, res)
+	assert.Equal(t, selector+1, testutil.ToFloat64(alertsFilteredTotal.WithLabelValues(filterReasonSelector)))
+	assert.Equal(t, exclude+1, testutil.ToFloat64(alertsFilteredTotal.WithLabelValues(filterReasonExcludeLabel)))
+	plugin.LabelSelector = nil
+	plugin.ExcludeLabels = nil
+}
+
+func TestProcessAlertsMetrics(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer agent.Close()
+	plugin.AgentAPIURL = agent.URL
+	now := time.Now()
+	resolved := testAlert("b", "NodeDown", now)
+	state := "suppressed"
+	resolved.Status.State = &state
+	failure := testutil.ToFloat64(eventsPostedTotal.WithLabelValues("failure"))
+	notActive := testutil.ToFloat64(alertsFilteredTotal.WithLabelValues(filterReasonNotActive))
+	excluded := testutil.ToFloat64(alertsFilteredTotal.WithLabelValues(filterReasonExcludedAlertname))
+	count := processAlertsToSensuAgent([]models.GettableAlert{testAlert("a", "TargetDown", now), resolved, testAlert("c", "Watchdog", now)}, []string{"Watchdog", ""})
+	assert.Equal(t, 1, count)
+	assert.Equal(t, failure+1, testutil.ToFloat64(eventsPostedTotal.WithLabelValues("failure")))
+	assert.Equal(t, notActive+1, testutil.ToFloat64(alertsFilteredTotal.WithLabelValues(filterReasonNotActive)))
+	assert.Equal(t, excluded+1, testutil.ToFloat64(alertsFilteredTotal.WithLabelValues(filterReasonExcludedAlertname)))
+}
+
+func TestCloseEventsMetrics(t *testing.T) {
+	status := http.StatusInternalServerError
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer agent.Close()
+	plugin.AgentAPIURL = agent.URL
+	alerts := []models.GettableAlert{testAlert("a", "TargetDown", time.Now())}
+	success := testutil.ToFloat64(eventsClosedTotal.WithLabelValues("success"))
+	failure := testutil.ToFloat64(eventsClosedTotal.WithLabelValues("failure"))
+	assert.Equal(t, 1, processSensuEventsToClose([]*v2.Event{testSensuEvent("PodDown", "z")}, alerts))
+	assert.Equal(t, failure+1, testutil.ToFloat64(eventsClosedTotal.WithLabelValues("failure")))
+	status = http.StatusOK
+	assert.Equal(t, 0, processSensuEventsToClose([]*v2.Event{testSensuEvent("PodDown", "z"), testSensuEvent("TargetDown", "a")}, alerts))
+	assert.Equal(t, success+1, testutil.ToFloat64(eventsClosedTotal.WithLabelValues("success")))
+	assert.Equal(t, failure+1, testutil.ToFloat64(eventsClosedTotal.WithLabelValues("failure")))
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	observeRequest(targetAgent, time.Now())
+	rec := httptest.NewRecorder()
+	newServeMux().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body, _ := ioutil.ReadAll(rec.Body)
+	assert.Contains(t, string(body), "sensu_alertmanager_events_request_duration_seconds")
+	assert.Contains(t, string(body), "sensu_alertmanager_events_alerts_fetched_total")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultConcurrency is used when --concurrency is not set
+const defaultConcurrency = 10
+
+// postLimiter bounds how many posts run at the same time and how many start per second.
+// It is shared by all workers, so alerts and auto close posts running together use the same limits
+type postLimiter struct {
+	slots  chan struct{}
+	ticker *time.Ticker
+}
+
+var limiter = newPostLimiter(defaultConcurrency, 0)
+
+// create a postLimiter. A rate lower or equal to 0 disables the rate limit
+func newPostLimiter(concurrency int, rate float64) *postLimiter {
+	if concurrency < 1 {
+		concurrency = defaultConcurrency
+	}
+	l := &postLimiter{slots: make(chan struct{}, concurrency)}
+	if rate > 0 {
+		l.ticker = time.NewTicker(time.Duration(float64(time.Second) / rate))
+	}
+	return l
+}
+
+// replace the global limiter using --concurrency and --rate-limit
+func setPostLimiter(concurrency int, rate float64) {
+	if limiter.ticker != nil {
+		limiter.ticker.Stop()
+	}
+	limiter = newPostLimiter(concurrency, rate)
+}
+
+// wait for a free slot and, with a rate limit, for the next tick
+func (l *postLimiter) acquire() {
+	l.slots <- struct{}{}
+	if l.ticker != nil {
+		<-l.ticker.C
+	}
+}
+
+func (l *postLimiter) release() {
+	<-l.slots
+}
+
+// run fn for each item from 0 to n-1 using at most --concurrency workers.
+// Errors are returned in item order
+func runPool(n int, fn func(i int) error) []error {
+	errs := make([]error, n)
+	workers := plugin.Concurrency
+	if workers < 1 {
+		workers = defaultConcurrency
+	}
+	if workers > n {
+		workers = n
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return errs
+}
+
+// count errors returned by runPool
+func errorCount(errs []error) int {
+	count := 0
+	for _, err := range errs {
+		if err != nil {
+			count++
+		}
+	}
+	return count
+}
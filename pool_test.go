@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPool(t *testing.T) {
+	assert := assert.New(t)
+	plugin.Concurrency = 3
+	var running, maxRunning int32
+	errs := runPool(20, func(i int) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		if i%5 == 0 {
+			return fmt.Errorf("error %d", i)
+		}
+		return nil
+	})
+	assert.LessOrEqual(maxRunning, int32(3))
+	assert.Len(errs, 20)
+	assert.Equal(4, errorCount(errs))
+	assert.EqualError(errs[5], "error 5")
+	assert.NoError(errs[6])
+	assert.Empty(runPool(0, func(i int) error { return nil }))
+	plugin.Concurrency = 0
+}
+
+func TestPostLimiter(t *testing.T) {
+	assert := assert.New(t)
+	l := newPostLimiter(2, 0)
+	assert.Equal(2, cap(l.slots))
+	assert.Nil(l.ticker)
+	assert.Equal(defaultConcurrency, cap(newPostLimiter(0, 0).slots))
+
+	l = newPostLimiter(5, 100)
+	defer l.ticker.Stop()
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.acquire()
+			l.release()
+		}()
+	}
+	wg.Wait()
+	// 5 posts with 100 posts per second take at least 40ms
+	assert.GreaterOrEqual(time.Since(start), 40*time.Millisecond)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"gopkg.in/yaml.v2"
+)
+
+// relabel actions, the same from prometheus relabel_configs
+const (
+	relabelReplace   = "replace"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelLabelDrop = "labeldrop"
+	relabelLabelKeep = "labelkeep"
+	relabelLabelMap  = "labelmap"
+)
+
+// RelabelConfig represents one prometheus relabel_configs rule applied to alert labels
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    *string  `yaml:"separator"`
+	Regex        *string  `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  *string  `yaml:"replacement"`
+	Action       string   `yaml:"action"`
+	regex        *regexp.Regexp
+}
+
+// load relabel rules from --relabel-configs or --relabel-configs-file
+func loadRelabelConfigs() ([]RelabelConfig, error) {
+	if plugin.RelabelConfigsText != "" && plugin.RelabelConfigsFile != "" {
+		return nil, fmt.Errorf("Cannot use --relabel-configs and --relabel-configs-file together")
+	}
+	content := []byte(plugin.RelabelConfigsText)
+	if plugin.RelabelConfigsFile != "" {
+		var err error
+		content, err = ioutil.ReadFile(plugin.RelabelConfigsFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading --relabel-configs-file %s: %v", plugin.RelabelConfigsFile, err)
+		}
+	}
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return nil, nil
+	}
+	configs, err := parseRelabelConfigs(content)
+	if err != nil {
+		return nil, fmt.Errorf("Wrong relabel configs: %v", err)
+	}
+	return configs, nil
+}
+
+// parse relabel rules in YAML or JSON and set prometheus defaults
+func parseRelabelConfigs(content []byte) ([]RelabelConfig, error) {
+	configs := []RelabelConfig{}
+	err := yaml.UnmarshalStrict(content, &configs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range configs {
+		c := &configs[i]
+		if c.Action == "" {
+			c.Action = relabelReplace
+		}
+		c.Action = strings.ToLower(c.Action)
+		if c.Separator == nil {
+			separator := ";"
+			c.Separator = &separator
+		}
+		if c.Regex == nil {
+			regex := "(.*)"
+			c.Regex = &regex
+		}
+		if c.Replacement == nil {
+			replacement := "$1"
+			c.Replacement = &replacement
+		}
+		c.regex, err = regexp.Compile("^(?:" + *c.Regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid regex %s: %v", i, *c.Regex, err)
+		}
+		switch c.Action {
+		case relabelReplace:
+			if c.TargetLabel == "" {
+				return nil, fmt.Errorf("rule %d: target_label is required for action %s", i, c.Action)
+			}
+		case relabelKeep, relabelDrop:
+			if len(c.SourceLabels) == 0 {
+				return nil, fmt.Errorf("rule %d: source_labels is required for action %s", i, c.Action)
+			}
+		case relabelLabelDrop, relabelLabelKeep, relabelLabelMap:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %s", i, c.Action)
+		}
+	}
+	return configs, nil
+}
+
+// apply relabel rules in all alerts. Alerts dropped by keep or drop actions are removed
+func relabelAlerts(alerts []models.GettableAlert) []models.GettableAlert {
+	if len(plugin.RelabelConfigs) == 0 {
+		return alerts
+	}
+	result := []models.GettableAlert{}
+	for _, alert := range alerts {
+		labels, keep := relabel(alert.Labels, plugin.RelabelConfigs)
+		if !keep {
+			skipAlert(alert, filterReasonRelabel)
+			continue
+		}
+		alert.Labels = labels
+		result = append(result, alert)
+	}
+	return result
+}
+
+// apply relabel rules in a copy of labels. It returns false if labels must be dropped
+func relabel(labels models.LabelSet, configs []RelabelConfig) (models.LabelSet, bool) {
+	result := models.LabelSet{}
+	for k, v := range labels {
+		result[k] = v
+	}
+	for _, c := range configs {
+		values := make([]string, 0, len(c.SourceLabels))
+		for _, name := range c.SourceLabels {
+			values = append(values, result[name])
+		}
+		value := strings.Join(values, *c.Separator)
+		switch c.Action {
+		case relabelReplace:
+			indexes := c.regex.FindStringSubmatchIndex(value)
+			if indexes == nil {
+				continue
+			}
+			target := string(c.regex.ExpandString([]byte{}, c.TargetLabel, value, indexes))
+			replacement := string(c.regex.ExpandString([]byte{}, *c.Replacement, value, indexes))
+			if replacement == "" {
+				delete(result, target)
+				continue
+			}
+			result[target] = replacement
+		case relabelKeep:
+			if !c.regex.MatchString(value) {
+				return nil, false
+			}
+		case relabelDrop:
+			if c.regex.MatchString(value) {
+				return nil, false
+			}
+		case relabelLabelDrop:
+			for name := range result {
+				if c.regex.MatchString(name) {
+					delete(result, name)
+				}
+			}
+		case relabelLabelKeep:
+			for name := range result {
+				if !c.regex.MatchString(name) {
+					delete(result, name)
+				}
+			}
+		case relabelLabelMap:
+			current := models.LabelSet{}
+			for name, v := range result {
+				current[name] = v
+			}
+			for name, v := range current {
+				if c.regex.MatchString(name) {
+					result[c.regex.ReplaceAllString(name, *c.Replacement)] = v
+				}
+			}
+		}
+	}
+	return result, true
+}
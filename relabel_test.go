@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRelabelConfigs(t *testing.T) {
+	res1, err1 := parseRelabelConfigs([]byte(`[{"source_labels":["instance"],"regex":"(.*):\\d+","target_label":"host"}]`))
+	assert.NoError(t, err1)
+	assert.Len(t, res1, 1)
+	assert.Equal(t, relabelReplace, res1[0].Action)
+	assert.Equal(t, ";", *res1[0].Separator)
+	assert.Equal(t, "$1", *res1[0].Replacement)
+	res2, err2 := parseRelabelConfigs([]byte(`
+- action: labeldrop
+  regex: pod_template_hash|uid
+- action: drop
+  source_labels: [namespace]
+  regex: kube-.*
+`))
+	assert.NoError(t, err2)
+	assert.Len(t, res2, 2)
+	_, err3 := parseRelabelConfigs([]byte(`[{"action":"replace","source_labels":["a"]}]`))
+	assert.Error(t, err3)
+	_, err4 := parseRelabelConfigs([]byte(`[{"action":"hashmod","source_labels":["a"]}]`))
+	assert.Error(t, err4)
+	_, err5 := parseRelabelConfigs([]byte(`[{"action":"labeldrop","regex":"(a"}]`))
+	assert.Error(t, err5)
+	_, err6 := parseRelabelConfigs([]byte(`[{"action":"labeldrop","regexp":"a"}]`))
+	assert.Error(t, err6)
+}
+
+func TestRelabel(t *testing.T) {
+	labels := models.LabelSet{
+		"alertname":                  "KubePodCrashLooping",
+		"instance":                   "node1:9100",
+		"namespace":                  "default",
+		"pod_template_hash":          "abc123",
+		"__meta_kubernetes_pod_name": "api-0",
+	}
+	configs, err := parseRelabelConfigs([]byte(`
+- source_labels: [instance]
+  regex: (.*):\d+
+  target_label: host
+- source_labels: [alertname, namespace]
+  separator: /
+  target_label: key
+- action: labelmap
+  regex: __meta_kubernetes_(.*)
+- action: labeldrop
+  regex: pod_template_hash|__meta_.*
+- source_labels: [missing]
+  target_label: namespace
+  replacement: ""
+`))
+	assert.NoError(t, err)
+	res, keep := relabel(labels, configs)
+	assert.True(t, keep)
+	assert.Equal(t, models.LabelSet{
+		"alertname": "KubePodCrashLooping",
+		"instance":  "node1:9100",
+		"host":      "node1",
+		"key":       "KubePodCrashLooping/default",
+		"pod_name":  "api-0",
+	}, res)
+	// original labels are not changed
+	assert.Equal(t, "abc123", labels["pod_template_hash"])
+
+	keepConfigs, _ := parseRelabelConfigs([]byte(`[{"action":"keep","source_labels":["namespace"],"regex":"default|monitoring"}]`))
+	_, keep = relabel(labels, keepConfigs)
+	assert.True(t, keep)
+	_, keep = relabel(models.LabelSet{"namespace": "kube-system"}, keepConfigs)
+	assert.False(t, keep)
+	dropConfigs, _ := parseRelabelConfigs([]byte(`[{"action":"drop","source_labels":["namespace"],"regex":"kube-.*"}]`))
+	_, keep = relabel(models.LabelSet{"namespace": "kube-system"}, dropConfigs)
+	assert.False(t, keep)
+	labelKeepConfigs, _ := parseRelabelConfigs([]byte(`[{"action":"labelkeep","regex":"alertname|namespace"}]`))
+	res, _ = relabel(labels, labelKeepConfigs)
+	assert.Equal(t, models.LabelSet{"alertname": "KubePodCrashLooping", "namespace": "default"}, res)
+}
+
+func TestRelabelAlerts(t *testing.T) {
+	alert1 := testAlert("a", "TargetDown", time.Now())
+	alert1.Labels["namespace"] = "kube-system"
+	alert2 := testAlert("b", "NodeDown", time.Now())
+	alert2.Labels["namespace"] = "default"
+	plugin.RelabelConfigs, _ = parseRelabelConfigs([]byte(`[{"action":"drop","source_labels":["namespace"],"regex":"kube-.*"}]`))
+	res := relabelAlerts([]models.GettableAlert{alert1, alert2})
+	assert.Len(t, res, 1)
+	assert.Equal(t, "b", *res[0].Fingerprint)
+	plugin.RelabelConfigs = nil
+}
+
+func TestLoadRelabelConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sensu-alertmanager-events")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "relabel.yml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("- action: labeldrop\n  regex: uid\n"), 0600))
+	plugin.RelabelConfigsFile = file
+	res1, err1 := loadRelabelConfigs()
+	assert.NoError(t, err1)
+	assert.Len(t, res1, 1)
+	plugin.RelabelConfigsText = `[{"action":"labeldrop","regex":"uid"}]`
+	_, err2 := loadRelabelConfigs()
+	assert.Error(t, err2)
+	plugin.RelabelConfigsFile = ""
+	res3, err3 := loadRelabelConfigs()
+	assert.NoError(t, err3)
+	assert.Len(t, res3, 1)
+	plugin.RelabelConfigsText = ""
+	res4, err4 := loadRelabelConfigs()
+	assert.NoError(t, err4)
+	assert.Empty(t, res4)
+}
+
+func TestRelabelSelectorNotSentToAlertmanager(t *testing.T) {
+	assert := assert.New(t)
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// alert manager doesn't know labels created by relabel rules
+		if len(r.URL.Query()["filter"]) != 0 {
+			_, _ = w.Write([]byte("[]"))
+			return
+		}
+		prod := testAlert("a", "TargetDown", time.Now())
+		prod.Labels["namespace"] = "prod"
+		prod.Labels["instance"] = "node1:9100"
+		dev := testAlert("b", "TargetDown", time.Now())
+		dev.Labels["namespace"] = "dev"
+		body, _ := json.Marshal([]models.GettableAlert{prod, dev})
+		_, _ = w.Write(body)
+	}))
+	defer am.Close()
+	configs, err := parseRelabelConfigs([]byte(`
+- action: labelmap
+  regex: namespace
+  replacement: ns
+- source_labels: [instance]
+  regex: (.*):\d+
+  target_label: host
+`))
+	assert.NoError(err)
+	plugin.RelabelConfigs = configs
+	plugin.LabelSelector, _ = parseMatchers(`ns="prod",host="node1"`)
+	plugin.Sources = []AlertmanagerSource{{URL: am.URL}}
+
+	apiURL, err := alertsQueryURL(plugin.Sources[0])
+	assert.NoError(err)
+	u, _ := url.Parse(apiURL)
+	assert.Empty(u.Query()["filter"])
+
+	alerts, _, err := getAlertManagerEvents()
+	assert.NoError(err)
+	if assert.Len(alerts, 1) {
+		assert.Equal("a", *alerts[0].Fingerprint)
+	}
+
+	plugin.Sources = nil
+	plugin.LabelSelector = nil
+	plugin.RelabelConfigs = nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned when an endpoint failed too many times in the current run
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// retrySleep waits between retries. It is replaced in tests
+var retrySleep = time.Sleep
+
+// circuitBreaker counts consecutive failures by endpoint (host:port) in the current run
+type circuitBreaker struct {
+	sync.Mutex
+	failures map[string]int
+}
+
+var breaker = &circuitBreaker{failures: map[string]int{}}
+
+// reset all endpoints. It is called at the beginning of each run
+func (c *circuitBreaker) reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.failures = map[string]int{}
+}
+
+// check if endpoint reached --circuit-breaker-threshold consecutive failures
+func (c *circuitBreaker) isOpen(endpoint string) bool {
+	c.Lock()
+	defer c.Unlock()
+	return plugin.CircuitBreakerThreshold > 0 && c.failures[endpoint] >= plugin.CircuitBreakerThreshold
+}
+
+// record a request result. Any success closes the circuit again
+func (c *circuitBreaker) record(endpoint string, failed bool) {
+	c.Lock()
+	defer c.Unlock()
+	if failed {
+		c.failures[endpoint]++
+		return
+	}
+	delete(c.failures, endpoint)
+}
+
+// execute an idempotent request with retries, exponential backoff and jitter.
+// newRequest is called in each attempt, so request body can be sent again
+func doWithRetry(client *http.Client, target string, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		endpoint := req.URL.Host
+		if breaker.isOpen(endpoint) {
+			return nil, fmt.Errorf("%w for %s after %d consecutive failures", errCircuitOpen, endpoint, plugin.CircuitBreakerThreshold)
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		observeRequest(target, start)
+		failed := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		breaker.record(endpoint, failed)
+		if !retryable(resp, err) || attempt >= plugin.Retries {
+			return resp, err
+		}
+		delay := retryBackoff(attempt)
+		reason := fmt.Sprintf("%v", err)
+		if resp != nil {
+			reason = resp.Status
+			if wait, ok := retryAfter(resp); ok {
+				delay = wait
+			}
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if max := time.Duration(plugin.RetryMaxBackoff) * time.Millisecond; max > 0 && delay > max {
+			delay = max
+		}
+		requestRetriesTotal.WithLabelValues(target).Inc()
+		log.Printf("Retrying %s %s in %s (attempt %d of %d): %s", req.Method, req.URL, delay, attempt+1, plugin.Retries, reason)
+		retrySleep(delay)
+	}
+}
+
+// connection errors and 429, 502, 503 and 504 responses can be retried
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// exponential backoff using --retry-backoff, limited by --retry-max-backoff, with jitter
+func retryBackoff(attempt int) time.Duration {
+	backoff := time.Duration(plugin.RetryBackoff) * time.Millisecond
+	if backoff <= 0 {
+		return 0
+	}
+	max := time.Duration(plugin.RetryMaxBackoff) * time.Millisecond
+	for i := 0; i < attempt; i++ {
+		backoff *= 2
+		if max > 0 && backoff >= max {
+			backoff = max
+			break
+		}
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// read Retry-After header (seconds or http date) from 429 and 503 responses
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
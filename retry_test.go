@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// replace retrySleep and return slept durations
+func recordRetrySleep() *[]time.Duration {
+	slept := []time.Duration{}
+	retrySleep = func(d time.Duration) {
+		slept = append(slept, d)
+	}
+	return &slept
+}
+
+func TestDoWithRetry(t *testing.T) {
+	assert := assert.New(t)
+	slept := recordRetrySleep()
+	defer func() { retrySleep = time.Sleep }()
+	var calls int32
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer agent.Close()
+	plugin.AgentAPIURL = agent.URL
+	plugin.Retries = 3
+	plugin.RetryBackoff = 100
+	plugin.RetryMaxBackoff = 5000
+
+	assert.NoError(submitEventAgentAPI(&v2.Event{Check: v2.FixtureCheck("test")}))
+	assert.Equal(int32(3), calls)
+	if assert.Len(*slept, 2) {
+		assert.Equal(2*time.Second, (*slept)[0])
+		assert.True((*slept)[1] >= 100*time.Millisecond && (*slept)[1] <= 200*time.Millisecond)
+	}
+
+	// not retried
+	atomic.StoreInt32(&calls, 10)
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	plugin.AgentAPIURL = notFound.URL
+	assert.Error(submitEventAgentAPI(&v2.Event{Check: v2.FixtureCheck("test")}))
+	assert.Len(*slept, 2)
+
+	plugin.AgentAPIURL = ""
+	plugin.Retries = 0
+	plugin.RetryBackoff = 0
+	plugin.RetryMaxBackoff = 0
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+	recordRetrySleep()
+	defer func() { retrySleep = time.Sleep }()
+	var calls int32
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer agent.Close()
+	plugin.AgentAPIURL = agent.URL
+	plugin.Retries = 2
+	plugin.CircuitBreakerThreshold = 4
+	breaker.reset()
+
+	err := submitEventAgentAPI(&v2.Event{Check: v2.FixtureCheck("test")})
+	assert.Error(err)
+	assert.False(errors.Is(err, errCircuitOpen))
+	assert.Equal(int32(3), calls)
+	// circuit opens after the 4th failure and stops retries
+	assert.Error(submitEventAgentAPI(&v2.Event{Check: v2.FixtureCheck("test")}))
+	assert.Equal(int32(4), calls)
+	err = submitEventAgentAPI(&v2.Event{Check: v2.FixtureCheck("test")})
+	assert.Contains(err.Error(), errCircuitOpen.Error())
+	assert.Equal(int32(4), calls)
+
+	breaker.reset()
+	assert.Error(submitEventAgentAPI(&v2.Event{Check: v2.FixtureCheck("test")}))
+	assert.Equal(int32(7), calls)
+
+	breaker.reset()
+	plugin.AgentAPIURL = ""
+	plugin.Retries = 0
+	plugin.CircuitBreakerThreshold = 0
+}
+
+func TestRetryBackoff(t *testing.T) {
+	plugin.RetryBackoff = 100
+	plugin.RetryMaxBackoff = 300
+	for attempt, max := range []time.Duration{100, 200, 300, 300} {
+		d := retryBackoff(attempt)
+		assert.True(t, d >= max*time.Millisecond/2 && d <= max*time.Millisecond, "attempt %d: %s", attempt, d)
+	}
+	plugin.RetryBackoff = 0
+	assert.Equal(t, time.Duration(0), retryBackoff(1))
+	plugin.RetryMaxBackoff = 0
+}
+
+func TestRetryAfter(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	_, ok := retryAfter(resp)
+	assert.False(t, ok)
+	resp.Header.Set("Retry-After", "3")
+	d, ok := retryAfter(resp)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+	resp.Header.Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+	d, ok = retryAfter(resp)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), d)
+	resp.StatusCode = http.StatusBadGateway
+	_, ok = retryAfter(resp)
+	assert.False(t, ok)
+}
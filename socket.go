@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// delivery modes using sensu agent socket
+const (
+	deliveryTCP = "tcp"
+	deliveryUDP = "udp"
+)
+
+// socketTimeout is used to connect and write in sensu agent socket
+const socketTimeout = 10 * time.Second
+
+// SocketCheckResult is the check result format accepted by sensu agent socket
+type SocketCheckResult struct {
+	Name     string   `json:"name"`
+	Status   uint32   `json:"status"`
+	Output   string   `json:"output"`
+	Source   string   `json:"source,omitempty"`
+	Handlers []string `json:"handlers,omitempty"`
+	Executed int64    `json:"executed,omitempty"`
+}
+
+// create sensu agent socket check result from event
+func newSocketCheckResult(event *v2.Event) SocketCheckResult {
+	return SocketCheckResult{
+		Name:     event.Check.Name,
+		Status:   event.Check.Status,
+		Output:   event.Check.Output,
+		Source:   event.Check.ProxyEntityName,
+		Handlers: event.Check.Handlers,
+		Executed: time.Now().Unix(),
+	}
+}
+
+// write event in sensu agent socket using tcp or udp
+func submitEventAgentSocket(event *v2.Event) error {
+	encoded, _ := json.Marshal(newSocketCheckResult(event))
+	start := time.Now()
+	defer observeRequest(targetAgent, start)
+	conn, err := net.DialTimeout(plugin.Delivery, plugin.AgentSocketAddress, socketTimeout)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to agent socket %s://%s: %v", plugin.Delivery, plugin.AgentSocketAddress, err)
+	}
+	defer conn.Close()
+	err = conn.SetWriteDeadline(time.Now().Add(socketTimeout))
+	if err != nil {
+		return fmt.Errorf("Failed to set deadline in agent socket %s://%s: %v", plugin.Delivery, plugin.AgentSocketAddress, err)
+	}
+	_, err = conn.Write(encoded)
+	if err != nil {
+		return fmt.Errorf("Write of event to agent socket %s://%s failed: %v\nevent: %s", plugin.Delivery, plugin.AgentSocketAddress, err, string(encoded))
+	}
+	return nil
+}
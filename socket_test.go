@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSocketEvent() *v2.Event {
+	return &v2.Event{
+		Check: &v2.Check{
+			ObjectMeta:      v2.ObjectMeta{Name: "TargetDown", Namespace: "default"},
+			ProxyEntityName: "proxy1",
+			Output:          "target is down",
+			Status:          2,
+			Handlers:        []string{"slack", "email"},
+		},
+	}
+}
+
+func TestSubmitEventAgentSocketTCP(t *testing.T) {
+	assert := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer listener.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		body, _ := ioutil.ReadAll(conn)
+		received <- body
+	}()
+	plugin.Delivery = deliveryTCP
+	plugin.AgentSocketAddress = listener.Addr().String()
+	assert.NoError(submitEvent(testSocketEvent()))
+
+	result := SocketCheckResult{}
+	assert.NoError(json.Unmarshal(<-received, &result))
+	assert.Equal("TargetDown", result.Name)
+	assert.Equal(uint32(2), result.Status)
+	assert.Equal("target is down", result.Output)
+	assert.Equal("proxy1", result.Source)
+	assert.Equal([]string{"slack", "email"}, result.Handlers)
+	assert.NotZero(result.Executed)
+
+	listener.Close()
+	assert.Error(submitEvent(testSocketEvent()))
+	plugin.Delivery = ""
+	plugin.AgentSocketAddress = ""
+}
+
+func TestSubmitEventAgentSocketUDP(t *testing.T) {
+	assert := assert.New(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer conn.Close()
+	plugin.Delivery = deliveryUDP
+	plugin.AgentSocketAddress = conn.LocalAddr().String()
+	assert.NoError(submitEvent(testSocketEvent()))
+
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(err)
+	result := SocketCheckResult{}
+	assert.NoError(json.Unmarshal(buf[:n], &result))
+	assert.Equal("TargetDown", result.Name)
+	assert.Equal("proxy1", result.Source)
+	plugin.Delivery = ""
+	plugin.AgentSocketAddress = ""
+}
+
+func TestCheckArgsSocketAutoClose(t *testing.T) {
+	assert := assert.New(t)
+	plugin.SensuAutoClose = true
+	for _, delivery := range []string{deliveryTCP, deliveryUDP} {
+		plugin.Delivery = delivery
+		status, err := checkArgs(nil)
+		if assert.Error(err) {
+			assert.Contains(err.Error(), "--auto-close-sensu")
+		}
+		assert.Equal(sensu.CheckStateWarning, status)
+	}
+	plugin.SensuAutoClose = false
+	_, err := checkArgs(nil)
+	assert.NoError(err)
+	plugin.Delivery = ""
+}
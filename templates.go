@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/alertmanager/api/v2/models"
+)
+
+// TemplateData represents the alert data available in templates
+type TemplateData struct {
+	Labels       map[string]string
+	Annotations  map[string]string
+	Fingerprint  string
+	Status       string
+	StartsAt     time.Time
+	GeneratorURL string
+	Value        string
+}
+
+// functions available in templates
+var templateFuncs = template.FuncMap{
+	"default":  templateDefault,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+	"sanitize": removeSpecialCharacters,
+	"trimPort": trimPort,
+}
+
+// parse template with helper functions
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// execute template using alert data
+func executeTemplate(tmpl *template.Template, alert models.GettableAlert) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, newTemplateData(alert))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func newTemplateData(alert models.GettableAlert) TemplateData {
+	data := TemplateData{
+		Labels:       make(map[string]string),
+		Annotations:  make(map[string]string),
+		GeneratorURL: string(alert.GeneratorURL),
+	}
+	for k, v := range alert.Labels {
+		data.Labels[k] = v
+	}
+	for k, v := range alert.Annotations {
+		data.Annotations[k] = v
+	}
+	if alert.Fingerprint != nil {
+		data.Fingerprint = *alert.Fingerprint
+	}
+	if alert.Status != nil && alert.Status.State != nil {
+		data.Status = *alert.Status.State
+	}
+	if alert.StartsAt != nil {
+		data.StartsAt = time.Time(*alert.StartsAt)
+	}
+	// alert manager doesn't have alert value, then we use value annotation if it exists
+	data.Value = data.Annotations["value"]
+	return data
+}
+
+// prometheusTemplatePrefix defines the same variables used in prometheus rules templates
+const prometheusTemplatePrefix = "{{ $labels := .Labels }}{{ $annotations := .Annotations }}{{ $value := .Value }}{{ $startsAt := .StartsAt }}"
+
+// render prometheus style templates like {{ $labels.alertname }} in each value
+// values without templates or with errors are returned without changes
+func renderTemplateValues(values map[string]string, alert models.GettableAlert) map[string]string {
+	var data *TemplateData
+	for k, v := range values {
+		if !strings.Contains(v, "{{") {
+			continue
+		}
+		if data == nil {
+			d := newTemplateData(alert)
+			data = &d
+		}
+		rendered, err := renderPrometheusTemplate(k, v, data)
+		if err != nil {
+			log.Printf("Cannot render template in %s: %v", k, err)
+			continue
+		}
+		values[k] = rendered
+	}
+	return values
+}
+
+func renderPrometheusTemplate(name, text string, data *TemplateData) (string, error) {
+	tmpl, err := parseTemplate(name, prometheusTemplatePrefix+text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// returns value or default value if it is empty. e. {{ .Labels.instance | default "unknown" }}
+func templateDefault(defaultValue string, value interface{}) string {
+	if value == nil {
+		return defaultValue
+	}
+	if s, ok := value.(string); ok && s != "" {
+		return s
+	}
+	return defaultValue
+}
+
+// remove port from instance label. e. node1:9100 to node1
+func trimPort(s string) string {
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return s
+	}
+	return host
+}
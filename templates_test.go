@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrimPort(t *testing.T) {
+	assert.Equal(t, "node1", trimPort("node1:9100"))
+	assert.Equal(t, "node1", trimPort("node1"))
+	assert.Equal(t, "::1", trimPort("[::1]:9100"))
+}
+
+func TestExecuteTemplate(t *testing.T) {
+	alert := testAlert("a", "InstanceDown", time.Now())
+	alert.Labels["instance"] = "Switch01.example.com:161"
+	alert.Annotations = models.LabelSet{"summary": "Switch down"}
+	tmpl1, err := parseTemplate("test", `{{ .Labels.alertname }}-{{ .Labels.instance | trimPort | lower | sanitize }}`)
+	assert.NoError(t, err)
+	res1, err := executeTemplate(tmpl1, alert)
+	assert.NoError(t, err)
+	assert.Equal(t, "InstanceDown-switch01.example.com", res1)
+	tmpl2, err := parseTemplate("test", `{{ .Labels.job | default "blackbox" }}/{{ .Annotations.summary }}/{{ .Fingerprint }}`)
+	assert.NoError(t, err)
+	res2, err := executeTemplate(tmpl2, alert)
+	assert.NoError(t, err)
+	assert.Equal(t, "blackbox/Switch down/a", res2)
+	_, err = parseTemplate("test", `{{ .Labels.alertname `)
+	assert.Error(t, err)
+	_, err = parseTemplate("test", `{{ .Labels.alertname | unknown }}`)
+	assert.Error(t, err)
+}
+
+func TestProcessAlertTemplates(t *testing.T) {
+	assert := assert.New(t)
+	var received *v2.Event
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = &v2.Event{}
+		assert.NoError(json.Unmarshal(body, received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer agent.Close()
+	plugin.AgentAPIURL = agent.URL
+	plugin.AlertmanagerLabelEntity = ""
+	plugin.SensuProxyEntity = ""
+	plugin.CheckNameTemplate = `{{ .Labels.alertname }}-{{ .Labels.job }}`
+	plugin.ProxyEntityTemplate = `{{ .Labels.instance | trimPort }}`
+	_, err := checkArgs(nil)
+	assert.NoError(err)
+	assert.Equal("ProxyEntityTemplate", plugin.ProxyEntity)
+	alert := testAlert("a", "SNMPDown", time.Now())
+	alert.Labels["instance"] = "switch01:161"
+	alert.Labels["job"] = "snmp"
+	assert.NoError(processAlert(alert, []string{}, 2))
+	assert.Equal("SNMPDown-snmp", received.Check.Name)
+	assert.Equal("switch01", received.Check.ProxyEntityName)
+
+	plugin.SensuProxyEntity = "k8s-cluster"
+	_, err = checkArgs(nil)
+	assert.Error(err)
+
+	plugin.SensuProxyEntity = ""
+	plugin.CheckNameTemplate = ""
+	plugin.ProxyEntityTemplate = ""
+	plugin.CheckNameTmpl = nil
+	plugin.ProxyEntityTmpl = nil
+	plugin.ProxyEntity = "KubernetesResource"
+}
+
+func TestPrintAlert(t *testing.T) {
+	alert := testAlert("a", "TargetDown", time.Now())
+	alert.Labels["job"] = "node"
+	alert.Labels["namespace"] = "monitoring"
+	alert.Annotations = models.LabelSet{"summary": "Targets are down", "description": "10% of node targets are down", "runbook_url": "https://runbooks"}
+	alert.GeneratorURL = "http://prometheus:9090/graph"
+
+	plugin.OutputFormat = "plain"
+	res1 := printAlert(alert, "TargetDown")
+	assert.Equal(t, "Labels: \n - alertname: TargetDown \n - job: node \n - namespace: monitoring \nAnnotations: \n - description: 10% of node targets are down \n - runbook_url: https://runbooks \n - summary: Targets are down \nAlert Manager: \n - status: active \nPrometheus:\n - source: http://prometheus:9090/graph \n", res1)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, res1, printAlert(alert, "TargetDown"))
+	}
+
+	plugin.OutputFormat = "summary"
+	assert.Equal(t, "Targets are down\n10% of node targets are down", printAlert(alert, "TargetDown"))
+
+	plugin.OutputFormat = "json"
+	res3 := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(printAlert(alert, "TargetDown")), &res3))
+	assert.Equal(t, "active", res3["status"])
+	assert.Equal(t, "http://prometheus:9090/graph", res3["generator_url"])
+
+	plugin.OutputFormat = "markdown"
+	res4 := printAlert(alert, "TargetDown")
+	assert.Contains(t, res4, "**TargetDown** (active)")
+	assert.Contains(t, res4, "- `job`: node\n- `namespace`: monitoring")
+	assert.Contains(t, res4, "[Prometheus](http://prometheus:9090/graph)")
+
+	plugin.OutputTmpl, _ = parseTemplate("output", `{{ .Annotations.summary }} ({{ .Status }}){{ range $k, $v := .Labels }} {{ $k }}={{ $v }}{{ end }}`)
+	assert.Equal(t, "Targets are down (active) alertname=TargetDown job=node namespace=monitoring", printAlert(alert, "TargetDown"))
+	plugin.OutputTmpl = nil
+	plugin.OutputFormat = ""
+}
+
+func TestRenderTemplateValues(t *testing.T) {
+	startsAt := time.Date(2021, 7, 28, 10, 0, 0, 0, time.UTC)
+	alert := testAlert("a", "KubePodCrashLooping", time.Now())
+	start := strfmt.DateTime(startsAt)
+	alert.StartsAt = &start
+	alert.Labels["pod"] = "api-0"
+	alert.Annotations = models.LabelSet{"value": "5"}
+	values := map[string]string{
+		"runbook":     "https://wiki/{{ $labels.alertname }}",
+		"description": "Pod {{ $labels.pod }} restarted {{ $value }} times since {{ $startsAt.Format \"2006-01-02\" }}",
+		"plain":       "no template",
+		"broken":      "{{ $labels.pod ",
+	}
+	res := renderTemplateValues(values, alert)
+	assert.Equal(t, "https://wiki/KubePodCrashLooping", res["runbook"])
+	assert.Equal(t, "Pod api-0 restarted 5 times since 2021-07-28", res["description"])
+	assert.Equal(t, "no template", res["plain"])
+	assert.Equal(t, "{{ $labels.pod ", res["broken"])
+}
+
+func TestProcessAlertRenderAnnotations(t *testing.T) {
+	assert := assert.New(t)
+	var received *v2.Event
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = &v2.Event{}
+		assert.NoError(json.Unmarshal(body, received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer agent.Close()
+	plugin.AgentAPIURL = agent.URL
+	plugin.SensuExtraAnnotation = "runbook=https://wiki/{{ $labels.alertname }}"
+	plugin.SensuExtraLabel = "team={{ $labels.team }}"
+	plugin.RewriteAnnotation = "priority=sensu.io/plugins/sensu-opsgenie-handler/config/priority"
+	alert := testAlert("a", "TargetDown", time.Now())
+	alert.Labels["team"] = "sre"
+	alert.Labels["severity"] = "critical"
+	alert.Annotations = models.LabelSet{"priority": "{{ if eq $labels.severity \"critical\" }}P1{{ else }}P3{{ end }}", "summary": "{{ $labels.alertname }} is firing"}
+	assert.NoError(processAlert(alert, []string{}, 2))
+	assert.Equal("https://wiki/TargetDown", received.Check.Annotations["runbook"])
+	assert.Equal("P1", received.Check.Annotations["sensu.io/plugins/sensu-opsgenie-handler/config/priority"])
+	assert.Equal("TargetDown is firing", received.Check.Annotations["summary"])
+	assert.Equal("sre", received.Check.Labels["team"])
+	plugin.SensuExtraAnnotation = ""
+	plugin.SensuExtraLabel = ""
+	plugin.RewriteAnnotation = ""
+}
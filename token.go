@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin is how long before expiration an access token is refreshed
+const tokenRefreshMargin = time.Minute
+
+// tokenCache keeps sensu backend access token between runs and in --api-backend-token-file
+var tokenCache struct {
+	sync.Mutex
+	auth   Auth
+	loaded bool
+}
+
+// get sensu backend access token from cache, refreshing it with /auth/token before it expires
+// a new login with /auth is used when there is no token or it cannot be refreshed
+func backendToken() (Auth, error) {
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+	if !tokenCache.loaded {
+		tokenCache.loaded = true
+		tokenCache.auth = loadTokenFile()
+	}
+	auth := tokenCache.auth
+	if auth.AccessToken != "" && !tokenExpiring(auth) {
+		return auth, nil
+	}
+	if auth.RefreshToken != "" {
+		refreshed, err := refreshToken(auth)
+		if err == nil {
+			storeToken(refreshed)
+			return refreshed, nil
+		}
+		log.Printf("Cannot refresh sensu backend access token, using a new login: %v", err)
+	}
+	auth, err := authenticate()
+	if err != nil {
+		tokenCache.auth = Auth{}
+		return auth, err
+	}
+	storeToken(auth)
+	return auth, nil
+}
+
+// drop access token rejected by sensu backend, so next backendToken call uses a new login
+func invalidateToken(rejected Auth) {
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+	if tokenCache.auth.AccessToken == rejected.AccessToken {
+		tokenCache.auth = Auth{}
+	}
+}
+
+// call fn using api key or access token. If access token is rejected (401), fn is called again after a new login
+func doWithBackendAuth(fn func(auth Auth) (*http.Response, error)) (*http.Response, error) {
+	if len(plugin.APIBackendKey) != 0 {
+		return fn(Auth{})
+	}
+	auth, err := backendToken()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fn(auth)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	log.Printf("Sensu backend access token was rejected, using a new login")
+	invalidateToken(auth)
+	auth, err = backendToken()
+	if err != nil {
+		return nil, err
+	}
+	return fn(auth)
+}
+
+// check if access token expires in less than tokenRefreshMargin. Tokens without expiration are used until a 401
+func tokenExpiring(auth Auth) bool {
+	return auth.ExpiresAt > 0 && time.Until(time.Unix(auth.ExpiresAt, 0)) < tokenRefreshMargin
+}
+
+// get a new access token using refresh token
+func refreshToken(auth Auth) (Auth, error) {
+	refreshed := Auth{}
+	body, _ := json.Marshal(map[string]string{"refresh_token": auth.RefreshToken})
+	req, err := http.NewRequest(http.MethodPost, backendURL("/auth/token"), bytes.NewReader(body))
+	if err != nil {
+		return refreshed, fmt.Errorf("error generating refresh token request: %v", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", auth.AccessToken))
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	resp, err := backendClient().Do(req)
+	observeRequest(targetBackend, start)
+	if err != nil {
+		return refreshed, fmt.Errorf("error executing refresh token request: %v", err)
+	}
+	defer resp.Body.Close()
+	result, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return refreshed, fmt.Errorf("error reading refresh token response: %v", err)
+	}
+	trim := 64
+	if resp.StatusCode != http.StatusOK {
+		return refreshed, fmt.Errorf("refresh token request failed with status %v\nFirst %d bytes of response: %s", resp.Status, trim, trimBody(result, trim))
+	}
+	err = json.Unmarshal(result, &refreshed)
+	if err != nil || refreshed.AccessToken == "" {
+		return refreshed, fmt.Errorf("error decoding refresh token response: %v\nFirst %d bytes of response: %s", err, trim, trimBody(result, trim))
+	}
+	return refreshed, nil
+}
+
+// keep token in memory and in --api-backend-token-file
+func storeToken(auth Auth) {
+	tokenCache.auth = auth
+	if plugin.APIBackendTokenFile == "" {
+		return
+	}
+	body, _ := json.Marshal(auth)
+	err := writeFileAtomic(plugin.APIBackendTokenFile, body)
+	if err != nil {
+		log.Printf("[ERROR] cannot write sensu backend token file %s: %v", plugin.APIBackendTokenFile, err)
+	}
+}
+
+// load token from --api-backend-token-file. An unreadable file is ignored, a new login replaces it
+func loadTokenFile() Auth {
+	auth := Auth{}
+	if plugin.APIBackendTokenFile == "" {
+		return auth
+	}
+	body, err := ioutil.ReadFile(plugin.APIBackendTokenFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[ERROR] cannot read sensu backend token file %s: %v", plugin.APIBackendTokenFile, err)
+		}
+		return auth
+	}
+	err = json.Unmarshal(body, &auth)
+	if err != nil {
+		log.Printf("[ERROR] cannot decode sensu backend token file %s: %v", plugin.APIBackendTokenFile, err)
+		return Auth{}
+	}
+	return auth
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// clear cached sensu backend token
+func resetTokenCache() {
+	tokenCache.Lock()
+	defer tokenCache.Unlock()
+	tokenCache.auth = Auth{}
+	tokenCache.loaded = false
+}
+
+func TestBackendToken(t *testing.T) {
+	assert := assert.New(t)
+	resetTokenCache()
+	var logins, refreshes int32
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			atomic.AddInt32(&logins, 1)
+			body, _ := json.Marshal(Auth{AccessToken: "login", RefreshToken: "refresh", ExpiresAt: expiresAt})
+			_, _ = w.Write(body)
+		case "/auth/token":
+			atomic.AddInt32(&refreshes, 1)
+			assert.Equal(http.MethodPost, r.Method)
+			body, _ := ioutil.ReadAll(r.Body)
+			if string(body) != `{"refresh_token":"refresh"}` {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body, _ = json.Marshal(Auth{AccessToken: "refreshed", RefreshToken: "refresh", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+			_, _ = w.Write(body)
+		}
+	}))
+	defer backend.Close()
+	setTestBackend(t, backend)
+	dir, err := ioutil.TempDir("", "token")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	plugin.APIBackendTokenFile = filepath.Join(dir, "token.json")
+
+	// login once and use cached token
+	auth, err := backendToken()
+	assert.NoError(err)
+	assert.Equal("login", auth.AccessToken)
+	_, _ = backendToken()
+	assert.Equal(int32(1), logins)
+	info, err := os.Stat(plugin.APIBackendTokenFile)
+	if assert.NoError(err) {
+		assert.Equal(os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// token from file is used by a new execution
+	resetTokenCache()
+	auth, err = backendToken()
+	assert.NoError(err)
+	assert.Equal("login", auth.AccessToken)
+	assert.Equal(int32(1), logins)
+
+	// expiring token is refreshed
+	tokenCache.auth.ExpiresAt = time.Now().Add(10 * time.Second).Unix()
+	auth, err = backendToken()
+	assert.NoError(err)
+	assert.Equal("refreshed", auth.AccessToken)
+	assert.Equal(int32(1), refreshes)
+	assert.Equal(int32(1), logins)
+
+	// refresh token rejected: new login
+	tokenCache.auth = Auth{AccessToken: "old", RefreshToken: "expired", ExpiresAt: 1}
+	auth, err = backendToken()
+	assert.NoError(err)
+	assert.Equal("login", auth.AccessToken)
+	assert.Equal(int32(2), logins)
+
+	resetTokenCache()
+	plugin.APIBackendTokenFile = ""
+}
+
+func TestBackendRequestUnauthorized(t *testing.T) {
+	assert := assert.New(t)
+	resetTokenCache()
+	var logins int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth" {
+			atomic.AddInt32(&logins, 1)
+			_, _ = w.Write([]byte(`{"access_token":"new","refresh_token":"refresh","expires_at":0}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer backend.Close()
+	setTestBackend(t, backend)
+	tokenCache.loaded = true
+	tokenCache.auth = Auth{AccessToken: "revoked"}
+
+	err := getEvents("default", func(events []*v2.Event) error { return nil })
+	assert.NoError(err)
+	assert.Equal(int32(1), logins)
+	assert.Equal("new", tokenCache.auth.AccessToken)
+	resetTokenCache()
+}
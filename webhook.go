@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/prometheus/alertmanager/api/v2/models"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+)
+
+// webhookVersion is the only Alert Manager webhook payload version supported
+const webhookVersion = "4"
+
+// WebhookMessage represents the Alert Manager webhook_configs payload
+type WebhookMessage struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   uint64            `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []WebhookAlert    `json:"alerts"`
+}
+
+// WebhookAlert represents one alert inside Alert Manager webhook payload
+type WebhookAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// start http server to receive alerts from alert manager
+// it stops gracefully on SIGTERM, waiting in-flight webhooks
+func serveWebhook() (int, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	mux := newServeMux()
+	mux.HandleFunc(plugin.WebhookPath, webhookHandler)
+	server := &http.Server{
+		Addr:    plugin.WebhookListenAddress,
+		Handler: mux,
+	}
+	log.Printf("Listening Alert Manager webhooks on %s%s", plugin.WebhookListenAddress, plugin.WebhookPath)
+	setReady(true)
+	err := serveHTTP(ctx, server)
+	if err != nil {
+		return sensu.CheckStateCritical, fmt.Errorf("webhook server failed: %v", err)
+	}
+	return sensu.CheckStateOK, nil
+}
+
+// webhooks are processed one at a time: dry run report and circuit breaker are shared and reset in each webhook
+var webhookMutex sync.Mutex
+
+// receive alert manager webhook and send alerts to sensu agent api
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("[ERROR] webhook ReadAll %s", err)
+		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return
+	}
+	message := WebhookMessage{}
+	err = json.Unmarshal(body, &message)
+	if err != nil {
+		trim := 64
+		log.Printf("[ERROR] webhook Unmarshal %s. First %d bytes of request: %s", err, trim, trimBody(body, trim))
+		http.Error(w, "cannot decode body", http.StatusBadRequest)
+		return
+	}
+	if message.Version != webhookVersion {
+		log.Printf("[ERROR] webhook version %s not supported", message.Version)
+		http.Error(w, fmt.Sprintf("webhook version %s not supported", message.Version), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Webhook received from %s with %d alerts, status %s, groupKey %s", message.Receiver, len(message.Alerts), message.Status, message.GroupKey)
+	webhookMutex.Lock()
+	defer webhookMutex.Unlock()
+	breaker.reset()
+	report.reset()
+	defer printDryRun()
+	err = prepareDelivery()
+	if err != nil {
+		log.Printf("[ERROR] webhook %s", err)
+		http.Error(w, "cannot authenticate in sensu backend", http.StatusInternalServerError)
+		return
+	}
+	countErrors := processWebhookMessage(message)
+	if countErrors != 0 {
+		// alert manager will retry it
+		http.Error(w, fmt.Sprintf("cannot create %d events in sensu", countErrors), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// send webhook alerts to sensu agent api. Resolved alerts are sent with status 0
+func processWebhookMessage(message WebhookMessage) int {
+	alertsFetchedTotal.Add(float64(len(message.Alerts)))
+	alerts := filterAlerts(relabelAlerts(webhookAlerts(message)))
+	AlertmanagerExcludeAlertList := excludeAlertList()
+	errs := runPool(len(alerts), func(i int) error {
+		a := alerts[i]
+		var sensuStatus uint32
+		if *a.Status.State != "resolved" {
+			status, send := alertSensuStatus(a)
+			if !send {
+				log.Printf("Skipping Alert %s by severity", a.Labels["alertname"])
+				skipAlert(a, filterReasonSeverity)
+				return nil
+			}
+			sensuStatus = status
+		}
+		return processAlert(a, AlertmanagerExcludeAlertList, sensuStatus)
+	})
+	return errorCount(errs)
+}
+
+// convert webhook alerts into alert manager api alerts
+func webhookAlerts(message WebhookMessage) []models.GettableAlert {
+	alerts := []models.GettableAlert{}
+	for _, a := range message.Alerts {
+		status := a.Status
+		if status == "" {
+			status = message.Status
+		}
+		state := "active"
+		if status == "resolved" {
+			state = "resolved"
+		}
+		labels := models.LabelSet{}
+		for k, v := range message.CommonLabels {
+			labels[k] = v
+		}
+		for k, v := range a.Labels {
+			labels[k] = v
+		}
+		annotations := models.LabelSet{}
+		for k, v := range a.Annotations {
+			annotations[k] = v
+		}
+		fingerprint := a.Fingerprint
+		startsAt := strfmt.DateTime(a.StartsAt)
+		endsAt := strfmt.DateTime(a.EndsAt)
+		updatedAt := strfmt.DateTime(time.Now())
+		alerts = append(alerts, models.GettableAlert{
+			Alert: models.Alert{
+				GeneratorURL: strfmt.URI(a.GeneratorURL),
+				Labels:       labels,
+			},
+			Annotations: annotations,
+			Fingerprint: &fingerprint,
+			StartsAt:    &startsAt,
+			EndsAt:      &endsAt,
+			UpdatedAt:   &updatedAt,
+			Status: &models.AlertStatus{
+				State:       &state,
+				InhibitedBy: []string{},
+				SilencedBy:  []string{},
+			},
+		})
+	}
+	return alerts
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	v2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookAlerts(t *testing.T) {
+	message := WebhookMessage{
+		Version:      "4",
+		Status:       "resolved",
+		CommonLabels: map[string]string{"cluster": "k8s-dev"},
+		Alerts: []WebhookAlert{
+			{Status: "firing", Labels: map[string]string{"alertname": "TargetDown"}, Fingerprint: "abc"},
+			{Labels: map[string]string{"alertname": "KubePodCrashLooping", "cluster": "k8s-prod"}, Fingerprint: "def"},
+		},
+	}
+	alerts := webhookAlerts(message)
+	assert.Len(t, alerts, 2)
+	assert.Equal(t, "active", *alerts[0].Status.State)
+	assert.Equal(t, "abc", *alerts[0].Fingerprint)
+	assert.Equal(t, "k8s-dev", alerts[0].Labels["cluster"])
+	assert.Equal(t, "resolved", *alerts[1].Status.State)
+	assert.Equal(t, "k8s-prod", alerts[1].Labels["cluster"])
+}
+
+func TestWebhookHandler(t *testing.T) {
+	assert := assert.New(t)
+	var mutex sync.Mutex
+	received := map[string]uint32{}
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(err)
+		event := &v2.Event{}
+		assert.NoError(json.Unmarshal(body, event))
+		mutex.Lock()
+		received[event.Check.Name] = event.Check.Status
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer agent.Close()
+	plugin.AgentAPIURL = agent.URL
+	plugin.AlertmanagerExcludeAlerts = "Watchdog,"
+	plugin.ProxyEntity = "SensuProxyEntity"
+	plugin.SensuProxyEntity = "k8s-cluster"
+
+	message := WebhookMessage{
+		Version: "4",
+		Status:  "firing",
+		Alerts: []WebhookAlert{
+			{Status: "firing", Labels: map[string]string{"alertname": "TargetDown"}, Fingerprint: "abc"},
+			{Status: "resolved", Labels: map[string]string{"alertname": "NodeDown", "node": "node1"}, Fingerprint: "def"},
+			{Status: "firing", Labels: map[string]string{"alertname": "Watchdog"}, Fingerprint: "ghi"},
+		},
+	}
+	body, _ := json.Marshal(message)
+	rec := httptest.NewRecorder()
+	webhookHandler(rec, httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body)))
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(map[string]uint32{"TargetDown": 2, "NodeDown-node1": 0}, received)
+
+	message.Version = "3"
+	body, _ = json.Marshal(message)
+	rec = httptest.NewRecorder()
+	webhookHandler(rec, httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body)))
+	assert.Equal(http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	webhookHandler(rec, httptest.NewRequest(http.MethodGet, "/webhook", nil))
+	assert.Equal(http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestWebhookHandlerConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	firstPosted := make(chan struct{})
+	secondPosted := make(chan struct{})
+	overlap := false
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &v2.Event{}
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.NoError(json.Unmarshal(body, event))
+		switch event.Check.Name {
+		case "First":
+			// keep the first webhook in flight, the second one should wait for it
+			close(firstPosted)
+			select {
+			case <-secondPosted:
+				overlap = true
+			case <-time.After(200 * time.Millisecond):
+			}
+		case "Second":
+			close(secondPosted)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer agent.Close()
+	plugin.AgentAPIURL = agent.URL
+	plugin.ProxyEntity = "SensuProxyEntity"
+	plugin.SensuProxyEntity = "k8s-cluster"
+
+	send := func(alertname string) int {
+		message := WebhookMessage{Version: "4", Status: "firing", Alerts: []WebhookAlert{{Labels: map[string]string{"alertname": alertname}, Fingerprint: alertname}}}
+		body, _ := json.Marshal(message)
+		rec := httptest.NewRecorder()
+		webhookHandler(rec, httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body)))
+		return rec.Code
+	}
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		codes[0] = send("First")
+	}()
+	<-firstPosted
+	go func() {
+		defer wg.Done()
+		codes[1] = send("Second")
+	}()
+	wg.Wait()
+	assert.Equal([]int{http.StatusOK, http.StatusOK}, codes)
+	assert.False(overlap, "webhooks were processed at the same time")
+}